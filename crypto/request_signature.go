@@ -0,0 +1,321 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	SIGNATURE_HEADER           = "X-Signature"
+	SIGNATURE_TIMESTAMP_HEADER = "X-Signature-Timestamp"
+	SIGNATURE_NONCE_HEADER     = "X-Signature-Nonce"
+
+	// maxSignedBodySize is the largest decoded body hashed for a signature.
+	maxSignedBodySize = 32 << 20
+)
+
+var (
+	// ErrBlankSigningKey is returned when a request is signed or verified
+	// without a key. The package key of SignedHash is never used for requests.
+	ErrBlankSigningKey = errors.New("Signing Key Is Blank")
+)
+
+type (
+	// RequestVerifier checks the signature of inbound requests, rejecting
+	// stale timestamps and nonces already seen within the window.
+	RequestVerifier struct {
+		Key string
+
+		// MaxSkew is how far the timestamp of a request may be from now.
+		// Nonces are remembered for twice that window. It must be positive.
+		MaxSkew time.Duration
+
+		lock      sync.Mutex
+		nonces    map[string]time.Time
+		lastPrune time.Time
+	}
+
+	// SignatureError is returned when a request signature is not valid.
+	SignatureError struct {
+		Reason string
+	}
+)
+
+// CanonicalRequest builds the message signed for a request. Both sides
+// must build it the same way:
+//
+//	timestamp "\n" nonce "\n" METHOD "\n" lowercase host "\n" escaped path "\n" sorted query "\n" hex(sha256(decoded body))
+//
+// The host binds the signature to the server it was sent to, so a request
+// can't be replayed against another host sharing the key. The body is
+// hashed as sent before any Content-Encoding is applied, see SignedBody.
+func CanonicalRequest(timestamp string, nonce string, method string, host string, path string, rawQuery string, body []byte) string {
+	if path == "" {
+		path = "/"
+	}
+
+	query, err := parseQuery(rawQuery)
+	if err != nil {
+		query = rawQuery
+	}
+
+	bodyHash := sha256.Sum256(body)
+
+	return strings.Join([]string{
+		timestamp,
+		nonce,
+		strings.ToUpper(method),
+		strings.ToLower(host),
+		path,
+		query,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+// SignRequest sets the signature headers of the request for the body
+// that will be sent, which may already be gzip encoded. The key must not
+// be blank.
+func SignRequest(req *http.Request, body []byte, key string) error {
+	if key == "" {
+		tracelog.ERROR(ErrBlankSigningKey, "go-common/crypto", "SignRequest")
+		return ErrBlankSigningKey
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		tracelog.ERROR(err, "go-common/crypto", "SignRequest")
+		return err
+	}
+
+	signedBody, err := SignedBody(req, body)
+	if err != nil {
+		tracelog.ERROR(err, "go-common/crypto", "SignRequest")
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	canonical := CanonicalRequest(timestamp, nonce, req.Method, requestHost(req), req.URL.EscapedPath(), req.URL.RawQuery, signedBody)
+
+	req.Header.Set(SIGNATURE_TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(SIGNATURE_NONCE_HEADER, nonce)
+	req.Header.Set(SIGNATURE_HEADER, SignedEncodedHash(canonical, key))
+	return nil
+}
+
+// NewRequestVerifier creates a verifier accepting timestamps within the
+// skew. The key must not be blank and the skew must be positive.
+func NewRequestVerifier(key string, maxSkew time.Duration) (*RequestVerifier, error) {
+	if key == "" {
+		tracelog.ERROR(ErrBlankSigningKey, "go-common/crypto", "NewRequestVerifier")
+		return nil, ErrBlankSigningKey
+	}
+
+	if maxSkew <= 0 {
+		err := fmt.Errorf("Invalid Signature Skew %v", maxSkew)
+		tracelog.ERROR(err, "go-common/crypto", "NewRequestVerifier")
+		return nil, err
+	}
+
+	verifier := &RequestVerifier{
+		Key:     key,
+		MaxSkew: maxSkew,
+	}
+
+	return verifier, nil
+}
+
+// Error returns the reason the signature was rejected.
+func (e *SignatureError) Error() string {
+	return "invalid request signature : " + e.Reason
+}
+
+// Verify checks the signature of the request. The body is read and
+// restored for the handler.
+func (rv *RequestVerifier) Verify(req *http.Request) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	return rv.VerifyBody(req, body)
+}
+
+// VerifyBody checks the signature of the request using a body that was
+// already read, such as the copy kept by the web framework. The body may
+// be gzip encoded or already decoded by the framework.
+func (rv *RequestVerifier) VerifyBody(req *http.Request, body []byte) error {
+	// A verifier built without NewRequestVerifier rejects everything
+	if rv.Key == "" || rv.MaxSkew <= 0 {
+		return rv.reject("verifier has no key or skew")
+	}
+
+	timestamp := req.Header.Get(SIGNATURE_TIMESTAMP_HEADER)
+	nonce := req.Header.Get(SIGNATURE_NONCE_HEADER)
+	signature := req.Header.Get(SIGNATURE_HEADER)
+
+	if timestamp == "" || nonce == "" || signature == "" {
+		return rv.reject("missing signature headers")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return rv.reject("invalid timestamp")
+	}
+
+	signedAt := time.Unix(seconds, 0)
+	if skew := time.Since(signedAt); skew > rv.MaxSkew || skew < -rv.MaxSkew {
+		return rv.reject("stale timestamp")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return rv.reject("invalid signature encoding")
+	}
+
+	signedBody, err := SignedBody(req, body)
+	if err != nil {
+		return rv.reject("invalid body encoding")
+	}
+
+	canonical := CanonicalRequest(timestamp, nonce, req.Method, requestHost(req), req.URL.EscapedPath(), req.URL.RawQuery, signedBody)
+	if !hmac.Equal(decoded, SignedHash(canonical, rv.Key)) {
+		return rv.reject("signature mismatch")
+	}
+
+	if !rv.useNonce(nonce) {
+		return rv.reject("replayed nonce")
+	}
+
+	return nil
+}
+
+// useNonce records the nonce, returning false if it was already used.
+func (rv *RequestVerifier) useNonce(nonce string) bool {
+	rv.lock.Lock()
+	defer rv.lock.Unlock()
+
+	now := time.Now()
+	window := 2 * rv.MaxSkew
+
+	if rv.nonces == nil {
+		rv.nonces = map[string]time.Time{}
+	}
+
+	// Forget the nonces whose timestamps are rejected anyway
+	if now.Sub(rv.lastPrune) > time.Second {
+		for seen, at := range rv.nonces {
+			if now.Sub(at) > window {
+				delete(rv.nonces, seen)
+			}
+		}
+		rv.lastPrune = now
+	}
+
+	if at, ok := rv.nonces[nonce]; ok && now.Sub(at) <= window {
+		return false
+	}
+
+	rv.nonces[nonce] = now
+	return true
+}
+
+// reject logs and returns the signature error.
+func (rv *RequestVerifier) reject(reason string) error {
+	err := &SignatureError{Reason: reason}
+	tracelog.ERROR(err, "go-common/crypto", "RequestVerifier.Verify")
+	return err
+}
+
+// SignedBody returns the representation of the body that is hashed in
+// the signature: the body without its Content-Encoding. A gzip body is
+// decoded, a body already decoded by the receiver is returned as is.
+func SignedBody(req *http.Request, body []byte) ([]byte, error) {
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if encoding != "gzip" || len(body) < 2 || body[0] != 0x1f || body[1] != 0x8b {
+		return body, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	decoded, err := ioutil.ReadAll(io.LimitReader(reader, maxSignedBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded) > maxSignedBodySize {
+		return nil, fmt.Errorf("Signed Body Exceeds %d Bytes", maxSignedBodySize)
+	}
+
+	return decoded, nil
+}
+
+// requestHost returns the host the request is sent to. Server requests
+// carry it in Host only.
+func requestHost(req *http.Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+
+	return req.URL.Host
+}
+
+// parseQuery sorts the query parameters by key.
+func parseQuery(rawQuery string) (string, error) {
+	if rawQuery == "" {
+		return "", nil
+	}
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", err
+	}
+
+	return values.Encode(), nil
+}
+
+// readBody reads the request body and puts it back.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// newNonce returns a random hex nonce.
+func newNonce() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("Unable To Generate Nonce : %v", err)
+	}
+
+	return hex.EncodeToString(nonce), nil
+}
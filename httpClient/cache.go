@@ -0,0 +1,356 @@
+package httpClient
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CACHE_STATUS_HEADER is set on responses going through the cache.
+	CACHE_STATUS_HEADER = "X-Http-Client-Cache"
+
+	CACHE_MISS        = "MISS"
+	CACHE_HIT         = "HIT"
+	CACHE_REVALIDATED = "REVALIDATED"
+
+	// maxCacheEntryBytes is the largest body the transport buffers for the cache.
+	maxCacheEntryBytes = 8 << 20
+)
+
+type (
+	// CacheStore stores the cached responses by key.
+	CacheStore interface {
+		Get(key string) (*CachedResponse, bool)
+		Set(key string, entry *CachedResponse)
+		Delete(key string)
+	}
+
+	// CachedResponse is a response kept by the cache.
+	CachedResponse struct {
+		StatusCode int
+		Status     string
+		Header     http.Header
+		Body       []byte
+
+		// Vary holds the request headers the response varies on.
+		Vary http.Header
+
+		StoredAt time.Time
+		Expires  time.Time
+
+		// NoCache, if true, forces a revalidation before every use.
+		NoCache bool
+	}
+
+	// cacheControl holds the parsed directives of a Cache-Control header.
+	cacheControl map[string]string
+
+	// cachingBody stores the response in the cache once it is fully read.
+	cachingBody struct {
+		io.ReadCloser
+		buf      bytes.Buffer
+		overflow bool
+		store    func(body []byte)
+	}
+)
+
+// Size returns the approximate number of bytes used by the entry.
+func (cr *CachedResponse) Size() int64 {
+	size := int64(len(cr.Body))
+	for key, values := range cr.Header {
+		size += int64(len(key))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+
+	return size
+}
+
+// isFresh checks if the entry can be used without revalidation.
+func (cr *CachedResponse) isFresh(now time.Time) bool {
+	return !cr.NoCache && now.Before(cr.Expires)
+}
+
+// canRevalidate checks if the entry has a validator.
+func (cr *CachedResponse) canRevalidate() bool {
+	return cr.Header.Get("ETag") != "" || cr.Header.Get("Last-Modified") != ""
+}
+
+// matches checks if the entry was stored for the same values of the
+// request headers listed by its Vary header.
+func (cr *CachedResponse) matches(req *http.Request) bool {
+	for key := range cr.Vary {
+		if req.Header.Get(key) != cr.Vary.Get(key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// response builds an http response from the entry.
+func (cr *CachedResponse) response(req *http.Request, cacheStatus string) *http.Response {
+	header := make(http.Header, len(cr.Header)+2)
+	for key, values := range cr.Header {
+		header[key] = append([]string(nil), values...)
+	}
+
+	age := int64(time.Since(cr.StoredAt) / time.Second)
+	header.Set("Age", strconv.FormatInt(age, 10))
+	header.Set(CACHE_STATUS_HEADER, cacheStatus)
+
+	resp := SyntheticResponse(req, cr.StatusCode, header, cr.Body)
+	resp.Status = cr.Status
+	return resp
+}
+
+// cacheKey returns the key used to store the responses of the url. The
+// cache is shared by all the callers of the transport, so the responses of
+// requests with credentials are never stored under it.
+func cacheKey(req *http.Request) string {
+	return req.URL.String()
+}
+
+// isAuthenticated checks if the request carries credentials, whose
+// responses may be specific to the caller.
+func isAuthenticated(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+// parseCacheControl parses the directives of the Cache-Control header.
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, part := range strings.Split(header.Get("Cache-Control"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if index := strings.Index(part, "="); index >= 0 {
+			cc[strings.ToLower(part[:index])] = strings.Trim(part[index+1:], `"`)
+			continue
+		}
+
+		cc[strings.ToLower(part)] = ""
+	}
+
+	return cc
+}
+
+// has checks if the directive is present.
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// duration returns the directive value as seconds.
+func (cc cacheControl) duration(directive string) (time.Duration, bool) {
+	value, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// expiration calculates when a response stored now becomes stale.
+func expiration(resp *http.Response, cc cacheControl, now time.Time) time.Time {
+	if maxAge, ok := cc.duration("max-age"); ok {
+		if age, err := strconv.ParseInt(resp.Header.Get("Age"), 10, 64); err == nil {
+			maxAge -= time.Duration(age) * time.Second
+		}
+		return now.Add(maxAge)
+	}
+
+	expires, err := http.ParseTime(resp.Header.Get("Expires"))
+	if err != nil {
+		return now
+	}
+
+	// Compute the lifetime with the server clock when possible
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		return now.Add(expires.Sub(date))
+	}
+
+	return expires
+}
+
+// newCachedResponse builds the cache entry for the response or returns nil
+// when the response can't be cached.
+func newCachedResponse(req *http.Request, resp *http.Response, now time.Time) *CachedResponse {
+	if resp.StatusCode != 200 {
+		return nil
+	}
+
+	// Responses meant for a single user must not be served to the others
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") || parseCacheControl(req.Header).has("no-store") {
+		return nil
+	}
+
+	vary := http.Header{}
+	for _, value := range resp.Header["Vary"] {
+		for _, key := range strings.Split(value, ",") {
+			key = strings.TrimSpace(key)
+			if key == "*" {
+				return nil
+			}
+			if key != "" {
+				vary.Set(key, req.Header.Get(key))
+			}
+		}
+	}
+
+	header := make(http.Header, len(resp.Header))
+	for key, values := range resp.Header {
+		header[key] = values
+	}
+
+	entry := &CachedResponse{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     header,
+		Vary:       vary,
+		StoredAt:   now,
+		Expires:    expiration(resp, cc, now),
+		NoCache:    cc.has("no-cache"),
+	}
+
+	// Without freshness or validators the entry would never be used
+	if !entry.isFresh(now) && !entry.canRevalidate() {
+		return nil
+	}
+
+	return entry
+}
+
+// cachedRoundTrip serves the request from the cache when possible and
+// stores the cacheable responses.
+func (t *Transport) cachedRoundTrip(req *http.Request) (*http.Response, error) {
+	key := cacheKey(req)
+
+	if req.Method != "GET" && req.Method != "HEAD" {
+		resp, err := t.roundTrip(req)
+		if err == nil && req.Method != "OPTIONS" && req.Method != "TRACE" && resp.StatusCode < 400 {
+			t.Cache.Delete(key)
+		}
+		return resp, err
+	}
+
+	// Partial, header only, caller validated and authenticated responses
+	// are not cached
+	if req.Method == "HEAD" || req.Header.Get("Range") != "" || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" || isAuthenticated(req) {
+		return t.roundTrip(req)
+	}
+
+	now := time.Now()
+	reqCC := parseCacheControl(req.Header)
+
+	entry, ok := t.Cache.Get(key)
+	if ok && !entry.matches(req) {
+		ok = false
+	}
+
+	fresh := ok && entry.isFresh(now) && !reqCC.has("no-cache")
+	if maxAge, set := reqCC.duration("max-age"); fresh && set {
+		fresh = now.Sub(entry.StoredAt) <= maxAge
+	}
+
+	if fresh {
+		return entry.response(req, CACHE_HIT), nil
+	}
+
+	validating := ok && entry.canRevalidate()
+	sendReq := req
+	if validating {
+		sendReq = CloneRequest(req)
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			sendReq.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			sendReq.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := t.roundTrip(sendReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if validating && resp.StatusCode == http.StatusNotModified {
+		drainBody(resp)
+
+		// Refresh the stored headers and freshness with the validation response
+		refreshed := *entry
+		refreshed.Header = make(http.Header, len(entry.Header))
+		for key, values := range entry.Header {
+			refreshed.Header[key] = values
+		}
+		for key, values := range resp.Header {
+			refreshed.Header[key] = values
+		}
+
+		now = time.Now()
+		cc := parseCacheControl(refreshed.Header)
+		if cc.has("no-store") || cc.has("private") {
+			t.Cache.Delete(key)
+			return refreshed.response(req, CACHE_REVALIDATED), nil
+		}
+
+		refreshed.StoredAt = now
+		refreshed.Expires = expiration(&http.Response{Header: refreshed.Header}, cc, now)
+		refreshed.NoCache = cc.has("no-cache")
+		t.Cache.Set(key, &refreshed)
+
+		return refreshed.response(req, CACHE_REVALIDATED), nil
+	}
+
+	entry = newCachedResponse(req, resp, now)
+	resp.Header.Set(CACHE_STATUS_HEADER, CACHE_MISS)
+	if entry == nil {
+		if ok {
+			t.Cache.Delete(key)
+		}
+		return resp, nil
+	}
+
+	resp.Body = &cachingBody{
+		ReadCloser: resp.Body,
+		store: func(body []byte) {
+			entry.Body = body
+			t.Cache.Set(key, entry)
+		},
+	}
+
+	return resp, nil
+}
+
+// Read buffers the body and stores the entry once the end is reached.
+func (cb *cachingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	if n > 0 && !cb.overflow {
+		if cb.buf.Len()+n > maxCacheEntryBytes {
+			cb.overflow = true
+			cb.buf = bytes.Buffer{}
+		} else {
+			cb.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !cb.overflow && cb.store != nil {
+		cb.store(cb.buf.Bytes())
+		cb.store = nil
+	}
+
+	return n, err
+}
@@ -0,0 +1,97 @@
+package httpClient
+
+import (
+	"container/list"
+	"sync"
+)
+
+type (
+	// MemoryCache is an in-memory CacheStore bounded by the number of bytes
+	// held. The least recently used entries are evicted first.
+	MemoryCache struct {
+		maxBytes int64
+		size     int64
+		lock     sync.Mutex
+		items    map[string]*list.Element
+		lru      *list.List
+	}
+
+	// memoryCacheItem is an element of the lru list.
+	memoryCacheItem struct {
+		key   string
+		entry *CachedResponse
+		size  int64
+	}
+)
+
+// NewMemoryCache creates an in-memory cache holding up to maxBytes.
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		items:    map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// Get returns the entry for the key and marks it as recently used.
+func (mc *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	element, ok := mc.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	mc.lru.MoveToFront(element)
+	return element.Value.(*memoryCacheItem).entry, true
+}
+
+// Set stores the entry evicting the least recently used entries as needed.
+// Entries larger than the cache are not stored.
+func (mc *MemoryCache) Set(key string, entry *CachedResponse) {
+	size := entry.Size() + int64(len(key))
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	mc.remove(key)
+	if size > mc.maxBytes {
+		return
+	}
+
+	mc.items[key] = mc.lru.PushFront(&memoryCacheItem{key: key, entry: entry, size: size})
+	mc.size += size
+
+	for mc.size > mc.maxBytes {
+		mc.remove(mc.lru.Back().Value.(*memoryCacheItem).key)
+	}
+}
+
+// Delete removes the entry for the key.
+func (mc *MemoryCache) Delete(key string) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	mc.remove(key)
+}
+
+// Size returns the number of bytes held by the cache.
+func (mc *MemoryCache) Size() int64 {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	return mc.size
+}
+
+// remove deletes the entry, the lock must be held.
+func (mc *MemoryCache) remove(key string) {
+	element, ok := mc.items[key]
+	if !ok {
+		return
+	}
+
+	mc.lru.Remove(element)
+	delete(mc.items, key)
+	mc.size -= element.Value.(*memoryCacheItem).size
+}
@@ -0,0 +1,262 @@
+package httpClient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	CIRCUIT_CLOSED CircuitState = iota
+	CIRCUIT_OPEN
+	CIRCUIT_HALF_OPEN
+)
+
+type (
+	// CircuitState is the state of the circuit breaker for a host.
+	CircuitState int
+
+	// BreakerPolicy controls when the circuit of an upstream host opens
+	// and how it recovers.
+	BreakerPolicy struct {
+		// FailureThreshold is the number of consecutive failures that
+		// opens the circuit.
+		FailureThreshold int
+
+		// CoolDown is the amount of time the circuit stays open before
+		// trial requests are let through again.
+		CoolDown time.Duration
+
+		// HalfOpenMaxRequests is the number of concurrent trial requests
+		// allowed while the circuit is half-open. Zero means one.
+		HalfOpenMaxRequests int
+
+		// SuccessThreshold is the number of successful trial requests
+		// required to close the circuit again. Zero means one.
+		SuccessThreshold int
+	}
+
+	// CircuitOpenError is returned when a request is rejected because
+	// the circuit of the upstream host is open.
+	CircuitOpenError struct {
+		Host    string
+		RetryAt time.Time
+	}
+
+	// circuitBreaker tracks the health of a single host.
+	circuitBreaker struct {
+		policy    *BreakerPolicy
+		lock      sync.Mutex
+		state     CircuitState
+		failures  int
+		successes int
+		inFlight  int
+		openedAt  time.Time
+	}
+)
+
+// NewBreakerPolicy creates a breaker policy that opens after the specified
+// number of consecutive failures and stays open for the cool down.
+func NewBreakerPolicy(failureThreshold int, coolDown time.Duration) *BreakerPolicy {
+	return &BreakerPolicy{
+		FailureThreshold:    failureThreshold,
+		CoolDown:            coolDown,
+		HalfOpenMaxRequests: 1,
+		SuccessThreshold:    1,
+	}
+}
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CIRCUIT_OPEN:
+		return "open"
+	case CIRCUIT_HALF_OPEN:
+		return "half-open"
+	}
+
+	return "closed"
+}
+
+// MarshalText encodes the state by name so it reads well on health endpoints.
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Error returns the error message for the rejected request.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for host %s until %s", e.Host, e.RetryAt.Format(time.RFC3339))
+}
+
+// BreakerState returns the current circuit state for the specified host.
+func (t *Transport) BreakerState(host string) CircuitState {
+	t.breakersLock.Lock()
+	breaker := t.breakers[host]
+	t.breakersLock.Unlock()
+
+	if breaker == nil {
+		return CIRCUIT_CLOSED
+	}
+
+	return breaker.currentState()
+}
+
+// BreakerStates returns the current circuit state of every host the
+// transport has talked to.
+func (t *Transport) BreakerStates() map[string]CircuitState {
+	t.breakersLock.Lock()
+	defer t.breakersLock.Unlock()
+
+	states := make(map[string]CircuitState, len(t.breakers))
+	for host, breaker := range t.breakers {
+		states[host] = breaker.currentState()
+	}
+
+	return states
+}
+
+// breakerFor returns the circuit breaker for the specified host, creating
+// it on first use. Nil is returned when no policy is configured.
+func (t *Transport) breakerFor(host string) *circuitBreaker {
+	if t.Breaker == nil || t.Breaker.FailureThreshold <= 0 {
+		return nil
+	}
+
+	t.breakersLock.Lock()
+	defer t.breakersLock.Unlock()
+
+	if t.breakers == nil {
+		t.breakers = map[string]*circuitBreaker{}
+	}
+
+	breaker := t.breakers[host]
+	if breaker == nil {
+		breaker = &circuitBreaker{policy: t.Breaker}
+		t.breakers[host] = breaker
+	}
+
+	return breaker
+}
+
+// isBreakerNeutral checks if the outcome of a request says nothing about
+// the health of the host, so it counts neither as a success nor a failure.
+func isBreakerNeutral(err error) bool {
+	var proxyErr *ProxyError
+	return isCanceled(err) || isPermanent(err) || errors.As(err, &proxyErr)
+}
+
+// isBreakerFailure checks if the outcome of a request counts against the host.
+func isBreakerFailure(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// currentState returns the state, moving an expired open circuit to half-open.
+func (cb *circuitBreaker) currentState() CircuitState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state == CIRCUIT_OPEN && time.Since(cb.openedAt) >= cb.policy.CoolDown {
+		return CIRCUIT_HALF_OPEN
+	}
+
+	return cb.state
+}
+
+// allow checks if a request may be sent to the host.
+func (cb *circuitBreaker) allow(host string) error {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state == CIRCUIT_OPEN {
+		if time.Since(cb.openedAt) < cb.policy.CoolDown {
+			return &CircuitOpenError{Host: host, RetryAt: cb.openedAt.Add(cb.policy.CoolDown)}
+		}
+
+		tracelog.INFO("http_client", "allow", "Circuit Half-Open Host[%s]", host)
+		cb.state = CIRCUIT_HALF_OPEN
+		cb.successes = 0
+		cb.inFlight = 0
+	}
+
+	if cb.state == CIRCUIT_HALF_OPEN {
+		maxRequests := cb.policy.HalfOpenMaxRequests
+		if maxRequests <= 0 {
+			maxRequests = 1
+		}
+
+		if cb.inFlight >= maxRequests {
+			return &CircuitOpenError{Host: host, RetryAt: time.Now().Add(cb.policy.CoolDown)}
+		}
+
+		cb.inFlight++
+	}
+
+	return nil
+}
+
+// record updates the breaker with the outcome of a request.
+func (cb *circuitBreaker) record(host string, failed bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case CIRCUIT_HALF_OPEN:
+		if cb.inFlight > 0 {
+			cb.inFlight--
+		}
+
+		if failed {
+			tracelog.WARN("http_client", "record", "Circuit Re-Opened Host[%s]", host)
+			cb.open()
+			return
+		}
+
+		successThreshold := cb.policy.SuccessThreshold
+		if successThreshold <= 0 {
+			successThreshold = 1
+		}
+
+		cb.successes++
+		if cb.successes >= successThreshold {
+			tracelog.INFO("http_client", "record", "Circuit Closed Host[%s]", host)
+			cb.state = CIRCUIT_CLOSED
+			cb.failures = 0
+		}
+
+	case CIRCUIT_CLOSED:
+		if !failed {
+			cb.failures = 0
+			return
+		}
+
+		cb.failures++
+		if cb.failures >= cb.policy.FailureThreshold {
+			tracelog.WARN("http_client", "record", "Circuit Opened Host[%s] Failures[%d]", host, cb.failures)
+			cb.open()
+		}
+	}
+}
+
+// release frees a half-open slot without recording an outcome, used when
+// the outcome is neutral.
+func (cb *circuitBreaker) release() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state == CIRCUIT_HALF_OPEN && cb.inFlight > 0 {
+		cb.inFlight--
+	}
+}
+
+// open moves the breaker to the open state.
+func (cb *circuitBreaker) open() {
+	cb.state = CIRCUIT_OPEN
+	cb.openedAt = time.Now()
+	cb.failures = 0
+	cb.successes = 0
+	cb.inFlight = 0
+}
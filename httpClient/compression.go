@@ -0,0 +1,161 @@
+package httpClient
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	// ACCEPT_ENCODING is sent when the caller does not choose the encodings.
+	ACCEPT_ENCODING = "gzip, deflate"
+)
+
+type (
+	// CompressionPolicy decodes gzip and deflate responses whatever the
+	// Accept-Encoding of the caller and compresses large request bodies.
+	CompressionPolicy struct {
+		// MaxDecompressedSize, if non-zero, is the maximum number of bytes
+		// of a decoded response body. Larger bodies are aborted with a
+		// *ResponseTooLargeError.
+		MaxDecompressedSize int64
+
+		// MinRequestSize, if non-zero, is the size from which in memory
+		// request bodies are sent gzip encoded. Streamed bodies are never
+		// compressed.
+		MinRequestSize int64
+	}
+
+	// decodingBody decodes the response body on first read.
+	decodingBody struct {
+		body     io.ReadCloser
+		encoding string
+		reader   io.Reader
+		err      error
+	}
+)
+
+// NewCompressionPolicy creates a policy limiting decoded bodies to 64MB
+// and compressing request bodies of 64KB or more.
+func NewCompressionPolicy() *CompressionPolicy {
+	return &CompressionPolicy{
+		MaxDecompressedSize: 64 << 20,
+		MinRequestSize:      64 << 10,
+	}
+}
+
+// prepareRequest asks for the supported encodings and compresses the body
+// when it is large enough.
+func (cp *CompressionPolicy) prepareRequest(req *http.Request) (*http.Request, error) {
+	req = CloneRequest(req)
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", ACCEPT_ENCODING)
+	}
+
+	if cp.MinRequestSize <= 0 || req.ContentLength < cp.MinRequestSize || req.GetBody == nil || req.Header.Get("Content-Encoding") != "" {
+		return req, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := io.Copy(writer, body); err != nil {
+		tracelog.ERROR(err, "http_client", "prepareRequest")
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		tracelog.ERROR(err, "http_client", "prepareRequest")
+		return nil, err
+	}
+
+	compressed := buf.Bytes()
+	tracelog.TRACE("http_client", "prepareRequest", "Compressed Url[%s] From[%d] To[%d]", req.URL, req.ContentLength, len(compressed))
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(compressed))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	req.ContentLength = int64(len(compressed))
+	req.Header.Set("Content-Encoding", "gzip")
+
+	return req, nil
+}
+
+// decodeResponse replaces a gzip or deflate body by its decoded content.
+// Unknown encodings are returned untouched.
+func (t *Transport) decodeResponse(req *http.Request, resp *http.Response) (*http.Response, error) {
+	if t.Compression == nil || resp.Uncompressed {
+		return resp, nil
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding != "gzip" && encoding != "x-gzip" && encoding != "deflate" {
+		return resp, nil
+	}
+
+	resp.Body = &decodingBody{body: resp.Body, encoding: encoding}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	if limit := t.Compression.MaxDecompressedSize; limit > 0 {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, req: req, remaining: limit, limit: limit}
+	}
+
+	return resp, nil
+}
+
+// Read decodes the body, creating the decoder on first use so empty
+// bodies are not an error.
+func (db *decodingBody) Read(p []byte) (int, error) {
+	if db.err != nil {
+		return 0, db.err
+	}
+
+	if db.reader == nil {
+		if db.reader, db.err = newDecoder(db.encoding, db.body); db.err != nil {
+			return 0, db.err
+		}
+	}
+
+	return db.reader.Read(p)
+}
+
+// Close closes the encoded body.
+func (db *decodingBody) Close() error {
+	return db.body.Close()
+}
+
+// newDecoder returns the decoder of the encoding. Deflate bodies are
+// zlib wrapped per the specification but some servers send raw deflate.
+func newDecoder(encoding string, body io.Reader) (io.Reader, error) {
+	if encoding != "deflate" {
+		return gzip.NewReader(body)
+	}
+
+	buffered := bufio.NewReader(body)
+	header, err := buffered.Peek(2)
+	if err != nil && len(header) == 0 {
+		return nil, err
+	}
+
+	if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+
+	return flate.NewReader(buffered), nil
+}
@@ -0,0 +1,85 @@
+package httpClient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type (
+	// ContextError is returned when a request is aborted because its
+	// context was cancelled or its deadline expired.
+	ContextError struct {
+		Method   string
+		URL      string
+		Deadline time.Time
+		Err      error
+		Cause    error
+	}
+
+	// requestTimeoutKey is the context key holding a per request timeout.
+	requestTimeoutKey struct{}
+)
+
+// WithRequestTimeout returns a context that makes the transport use the
+// specified timeout instead of its RequestTimeout.
+func WithRequestTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, requestTimeoutKey{}, timeout)
+}
+
+// requestTimeout returns the timeout stored in the context, if any.
+func requestTimeout(ctx context.Context) (time.Duration, bool) {
+	timeout, ok := ctx.Value(requestTimeoutKey{}).(time.Duration)
+	return timeout, ok
+}
+
+// Error returns the reason the request was aborted.
+func (e *ContextError) Error() string {
+	if e.Timeout() {
+		return fmt.Sprintf("%s %s : deadline exceeded at %s", e.Method, e.URL, e.Deadline.Format(time.RFC3339Nano))
+	}
+
+	return fmt.Sprintf("%s %s : request cancelled", e.Method, e.URL)
+}
+
+// Timeout returns true if the request was aborted because of a deadline.
+func (e *ContextError) Timeout() bool {
+	return e.Err == context.DeadlineExceeded
+}
+
+// Canceled returns true if the request was explicitly cancelled.
+func (e *ContextError) Canceled() bool {
+	return e.Err == context.Canceled
+}
+
+// Unwrap returns the context error.
+func (e *ContextError) Unwrap() error {
+	return e.Err
+}
+
+// contextError converts err into a ContextError when ctx is done.
+func contextError(ctx context.Context, req *http.Request, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+
+	if _, ok := err.(*ContextError); ok {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	return &ContextError{
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Deadline: deadline,
+		Err:      ctx.Err(),
+		Cause:    err,
+	}
+}
+
+// isCanceled checks if the error comes from the caller cancelling the request.
+func isCanceled(err error) bool {
+	e, ok := err.(*ContextError)
+	return ok && e.Canceled()
+}
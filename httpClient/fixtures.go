@@ -0,0 +1,398 @@
+package httpClient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	FIXTURE_RECORD FixtureMode = iota
+	FIXTURE_REPLAY
+)
+
+const (
+	// FIXTURE_BODY_BASE64 flags the bodies that are not valid UTF-8 and
+	// are stored base64 encoded.
+	FIXTURE_BODY_BASE64 = "base64"
+)
+
+type (
+	// FixtureMode selects between recording and replaying fixtures.
+	FixtureMode int
+
+	// MatchRules selects the parts of a request compared when replaying.
+	MatchRules struct {
+		Method bool
+		URL    bool
+		Query  bool
+		Body   bool
+	}
+
+	// FixtureRequest is the recorded request of an interaction.
+	FixtureRequest struct {
+		Method string      `json:"method"`
+		URL    string      `json:"url"`
+		Header http.Header `json:"header,omitempty"`
+		Body   string      `json:"body,omitempty"`
+
+		// BodyEncoding is FIXTURE_BODY_BASE64 for binary bodies, blank for text.
+		BodyEncoding string `json:"body_encoding,omitempty"`
+	}
+
+	// FixtureResponse is the recorded response of an interaction.
+	FixtureResponse struct {
+		StatusCode int         `json:"status_code"`
+		Header     http.Header `json:"header,omitempty"`
+		Body       string      `json:"body,omitempty"`
+
+		// BodyEncoding is FIXTURE_BODY_BASE64 for binary bodies, blank for text.
+		BodyEncoding string `json:"body_encoding,omitempty"`
+	}
+
+	// Interaction is a recorded request and response pair.
+	Interaction struct {
+		Request  FixtureRequest  `json:"request"`
+		Response FixtureResponse `json:"response"`
+	}
+
+	// Fixtures records the interactions of the transport to a file or
+	// replays them without contacting the upstream.
+	Fixtures struct {
+		Mode FixtureMode
+		Path string
+
+		// Match selects what must be equal for a recorded interaction to
+		// be replayed for a request.
+		Match MatchRules
+
+		// AllowRepeats, if true, replays the last matching interaction once
+		// all of them have been used.
+		AllowRepeats bool
+
+		// RedactHeaders lists the headers whose values are not recorded.
+		RedactHeaders []string
+
+		// RedactFields lists the JSON body fields whose values are not
+		// recorded. The same fields are redacted on replayed requests
+		// before they are compared.
+		RedactFields []string
+
+		lock         sync.Mutex
+		interactions []*Interaction
+		used         []bool
+		unmatched    []FixtureRequest
+	}
+
+	// UnmatchedRequestError is returned when no recorded interaction
+	// matches a replayed request.
+	UnmatchedRequestError struct {
+		Method string
+		URL    string
+	}
+)
+
+// NewRecorder creates fixtures recording the interactions into the file.
+func NewRecorder(path string) *Fixtures {
+	return &Fixtures{
+		Mode:          FIXTURE_RECORD,
+		Path:          path,
+		Match:         MatchRules{Method: true, URL: true, Query: true, Body: true},
+		RedactHeaders: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+	}
+}
+
+// NewReplayer creates fixtures replaying the interactions of the file.
+func NewReplayer(path string) (*Fixtures, error) {
+	fixtures := NewRecorder(path)
+	fixtures.Mode = FIXTURE_REPLAY
+
+	if err := fixtures.Load(); err != nil {
+		return nil, err
+	}
+
+	return fixtures, nil
+}
+
+// Error returns the error message for the unmatched request.
+func (e *UnmatchedRequestError) Error() string {
+	return fmt.Sprintf("no recorded fixture matches %s %s", e.Method, e.URL)
+}
+
+// Load reads the interactions from the file.
+func (f *Fixtures) Load() error {
+	contents, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "Fixtures.Load")
+		return err
+	}
+
+	var interactions []*Interaction
+	if err := json.Unmarshal(contents, &interactions); err != nil {
+		tracelog.ERROR(err, "http_client", "Fixtures.Load")
+		return err
+	}
+
+	for _, interaction := range interactions {
+		if _, err := decodeFixtureBody(interaction.Response.Body, interaction.Response.BodyEncoding); err != nil {
+			tracelog.ERROR(err, "http_client", "Fixtures.Load")
+			return err
+		}
+	}
+
+	f.lock.Lock()
+	f.interactions = interactions
+	f.used = make([]bool, len(interactions))
+	f.unmatched = nil
+	f.lock.Unlock()
+
+	return nil
+}
+
+// Save writes the interactions to the file.
+func (f *Fixtures) Save() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.save()
+}
+
+// Unmatched returns the replayed requests no interaction matched.
+func (f *Fixtures) Unmatched() []FixtureRequest {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]FixtureRequest(nil), f.unmatched...)
+}
+
+// save writes the interactions, the lock must be held.
+func (f *Fixtures) save() error {
+	contents, err := json.MarshalIndent(f.interactions, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(f.Path, contents, 0644)
+}
+
+// roundTrip records or replays the request.
+func (f *Fixtures) roundTrip(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtureReq := FixtureRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: f.redactHeaders(req.Header),
+	}
+	fixtureReq.Body, fixtureReq.BodyEncoding = encodeFixtureBody(f.redactBody(req.Header, body))
+
+	if f.Mode == FIXTURE_REPLAY {
+		return f.replay(req, fixtureReq)
+	}
+
+	// The body was consumed when it could not be rewound
+	sendReq := req
+	if req.GetBody == nil && req.Body != nil && req.Body != http.NoBody {
+		sendReq = CloneRequest(req)
+		sendReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		sendReq.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	resp, err := next(sendReq)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	interaction := &Interaction{
+		Request: fixtureReq,
+		Response: FixtureResponse{
+			StatusCode: resp.StatusCode,
+			Header:     f.redactHeaders(resp.Header),
+		},
+	}
+	interaction.Response.Body, interaction.Response.BodyEncoding = encodeFixtureBody(f.redactBody(resp.Header, respBody))
+
+	f.lock.Lock()
+	f.interactions = append(f.interactions, interaction)
+	f.used = append(f.used, true)
+	err = f.save()
+	f.lock.Unlock()
+
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "Fixtures.roundTrip")
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// replay returns the response of the first unused matching interaction.
+func (f *Fixtures) replay(req *http.Request, fixtureReq FixtureRequest) (*http.Response, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	last := -1
+	for index, interaction := range f.interactions {
+		if !f.matches(&interaction.Request, &fixtureReq) {
+			continue
+		}
+
+		last = index
+		if !f.used[index] {
+			f.used[index] = true
+			return interaction.response(req)
+		}
+	}
+
+	if last >= 0 && f.AllowRepeats {
+		return f.interactions[last].response(req)
+	}
+
+	f.unmatched = append(f.unmatched, fixtureReq)
+
+	err := &UnmatchedRequestError{Method: fixtureReq.Method, URL: fixtureReq.URL}
+	tracelog.ERROR(err, "http_client", "Fixtures.replay")
+	return nil, err
+}
+
+// matches compares the request with a recorded one using the match rules.
+func (f *Fixtures) matches(recorded *FixtureRequest, fixtureReq *FixtureRequest) bool {
+	if f.Match.Method && recorded.Method != fixtureReq.Method {
+		return false
+	}
+
+	recordedURL, err := url.Parse(recorded.URL)
+	if err != nil {
+		return false
+	}
+
+	reqURL, err := url.Parse(fixtureReq.URL)
+	if err != nil {
+		return false
+	}
+
+	if f.Match.URL && (recordedURL.Scheme != reqURL.Scheme || recordedURL.Host != reqURL.Host || recordedURL.Path != reqURL.Path) {
+		return false
+	}
+
+	if f.Match.Query && !reflect.DeepEqual(recordedURL.Query(), reqURL.Query()) {
+		return false
+	}
+
+	if f.Match.Body && (recorded.Body != fixtureReq.Body || recorded.BodyEncoding != fixtureReq.BodyEncoding) {
+		return false
+	}
+
+	return true
+}
+
+// redactHeaders returns a copy of the headers with the redacted values.
+func (f *Fixtures) redactHeaders(header http.Header) http.Header {
+	redacted := make(http.Header, len(header))
+	for key, values := range header {
+		if containsFold(f.RedactHeaders, key) {
+			redacted[key] = []string{REDACTED_VALUE}
+			continue
+		}
+		redacted[key] = append([]string(nil), values...)
+	}
+
+	return redacted
+}
+
+// redactBody redacts the fields of a JSON body.
+func (f *Fixtures) redactBody(header http.Header, body []byte) []byte {
+	if len(body) == 0 || len(f.RedactFields) == 0 || !strings.Contains(header.Get("Content-Type"), "json") {
+		return body
+	}
+
+	return redactJSONFields(body, f.RedactFields)
+}
+
+// response builds an http response from the recorded one.
+func (i *Interaction) response(req *http.Request) (*http.Response, error) {
+	body, err := decodeFixtureBody(i.Response.Body, i.Response.BodyEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header, len(i.Response.Header))
+	for key, values := range i.Response.Header {
+		header[key] = append([]string(nil), values...)
+	}
+
+	return SyntheticResponse(req, i.Response.StatusCode, header, body), nil
+}
+
+// encodeFixtureBody returns the body as text when it is valid UTF-8 and
+// base64 encoded otherwise, with its encoding.
+func encodeFixtureBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+
+	return base64.StdEncoding.EncodeToString(body), FIXTURE_BODY_BASE64
+}
+
+// decodeFixtureBody returns the bytes of a recorded body.
+func decodeFixtureBody(body string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(body), nil
+	case FIXTURE_BODY_BASE64:
+		return base64.StdEncoding.DecodeString(body)
+	}
+
+	return nil, fmt.Errorf("Unknown Fixture Body Encoding %s", encoding)
+}
+
+// readRequestBody reads the request body without consuming it for the
+// caller when it can be rewound.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+
+		return ioutil.ReadAll(body)
+	}
+
+	defer req.Body.Close()
+	return ioutil.ReadAll(req.Body)
+}
@@ -0,0 +1,207 @@
+package httpClient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	// maxHedgeTokens caps the hedges saved up by a quiet host.
+	maxHedgeTokens = 10
+)
+
+type (
+	// HedgePolicy sends a duplicate of a slow idempotent request and uses
+	// whichever response arrives first.
+	HedgePolicy struct {
+		// Delay is how long to wait for the first response before the
+		// duplicate is sent.
+		Delay time.Duration
+
+		// BudgetRatio is the fraction (0 to 1) of the requests of a host
+		// that may be hedged, so hedging never more than doubles the load.
+		BudgetRatio float64
+	}
+
+	// hedgeBudget tracks the hedges allowed for a host.
+	hedgeBudget struct {
+		lock   sync.Mutex
+		tokens float64
+	}
+
+	// hedgeResult is the outcome of one of the hedged requests.
+	hedgeResult struct {
+		index int
+		resp  *http.Response
+		err   error
+	}
+
+	// cancelOnClose releases the context of the winning request with its body.
+	cancelOnClose struct {
+		io.ReadCloser
+		cancel context.CancelFunc
+	}
+)
+
+// NewHedgePolicy creates a hedge policy with the delay allowing up to one
+// request in ten to be hedged.
+func NewHedgePolicy(delay time.Duration) *HedgePolicy {
+	return &HedgePolicy{
+		Delay:       delay,
+		BudgetRatio: 0.1,
+	}
+}
+
+// hedgeBudgetFor returns the budget of the host, creating it on first use.
+func (t *Transport) hedgeBudgetFor(host string) *hedgeBudget {
+	t.hedgesLock.Lock()
+	defer t.hedgesLock.Unlock()
+
+	if t.hedges == nil {
+		t.hedges = map[string]*hedgeBudget{}
+	}
+
+	budget := t.hedges[host]
+	if budget == nil {
+		budget = &hedgeBudget{}
+		t.hedges[host] = budget
+	}
+
+	return budget
+}
+
+// canHedge checks if the request may be duplicated.
+func (t *Transport) canHedge(req *http.Request) bool {
+	if t.Hedge == nil || t.Hedge.Delay <= 0 || t.Hedge.BudgetRatio <= 0 {
+		return false
+	}
+
+	return isIdempotent(req.Method) && canReplay(req)
+}
+
+// earn credits the budget for a request sent to the host.
+func (hb *hedgeBudget) earn(ratio float64) {
+	if ratio > 1 {
+		ratio = 1
+	}
+
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+
+	hb.tokens += ratio
+	if hb.tokens > maxHedgeTokens {
+		hb.tokens = maxHedgeTokens
+	}
+}
+
+// spend takes a hedge from the budget.
+func (hb *hedgeBudget) spend() bool {
+	hb.lock.Lock()
+	defer hb.lock.Unlock()
+
+	if hb.tokens < 1 {
+		return false
+	}
+
+	hb.tokens--
+	return true
+}
+
+// roundTripHedged sends the request and, if it is still running after the
+// delay and the budget allows it, a duplicate. The first response wins and
+// the other request is cancelled.
+func (t *Transport) roundTripHedged(req *http.Request) (*http.Response, error) {
+	budget := t.hedgeBudgetFor(req.URL.Host)
+	budget.earn(t.Hedge.BudgetRatio)
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+
+	// Every request gets its own copy of the headers so the layers further
+	// down can change them while the other request is running
+	launch := func(r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+
+		hedgeReq := r.Clone(ctx)
+		go func() {
+			resp, err := t.roundTripOnce(hedgeReq)
+			results <- hedgeResult{index: index, resp: resp, err: err}
+		}()
+	}
+
+	launch(req)
+	pending := 1
+
+	timer := time.NewTimer(t.Hedge.Delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			if !budget.spend() {
+				continue
+			}
+
+			hedgeReq, err := rewindRequest(req)
+			if err != nil {
+				continue
+			}
+
+			tracelog.TRACE("http_client", "roundTripHedged", "Hedging Url[%s] After[%v]", req.URL, t.Hedge.Delay)
+			launch(hedgeReq)
+			pending++
+
+		case result := <-results:
+			pending--
+
+			if result.err != nil {
+				if pending > 0 {
+					continue
+				}
+
+				for _, cancel := range cancels {
+					cancel()
+				}
+				return nil, result.err
+			}
+
+			// Cancel the losers, the winner keeps its context until its
+			// body is closed
+			for index, cancel := range cancels {
+				if index != result.index {
+					cancel()
+				}
+			}
+			result.resp.Body = &cancelOnClose{ReadCloser: result.resp.Body, cancel: cancels[result.index]}
+
+			if pending > 0 {
+				go discardHedges(results, pending)
+			}
+			return result.resp, nil
+		}
+	}
+}
+
+// discardHedges closes the responses of the requests that lost the race.
+func discardHedges(results chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		result := <-results
+		if result.resp != nil {
+			result.resp.Body.Close()
+		}
+	}
+}
+
+// Close closes the body and releases the request context.
+func (coc *cancelOnClose) Close() error {
+	err := coc.ReadCloser.Close()
+	coc.cancel()
+	return err
+}
@@ -3,11 +3,10 @@
 package httpClient
 
 import (
+	"context"
 	"crypto/tls"
-	"errors"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -23,14 +22,20 @@ type (
 
 	// Transport provides a thin wrapper arounf http.Transport.
 	Transport struct {
+		// BaseURL, if set, is joined to the relative urls of the requests.
+		BaseURL string
+
 		// Proxy specifies a function to return a proxy for a given
 		// *http.Request. If the function returns a non-nil error, the
 		// request is aborted with the provided error.
 		// If Proxy is nil or returns a nil *url.URL, no proxy is used.
+		// Use a ProxySelector to route hosts through different proxies.
+		// Failures at the proxy are returned as a *ProxyError.
 		Proxy func(*http.Request) (*url.URL, error)
 
 		// TLSClientConfig specifies the TLS configuration to use with
-		// tls.Client. If nil, the default configuration is used.
+		// tls.Client. If nil, the default configuration is used. Use
+		// ConfigureTLS for client certificates and pinning.
 		TLSClientConfig *tls.Config
 
 		// DisableKeepAlives, if true, prevents re-use of TCP connections
@@ -52,6 +57,23 @@ type (
 		// http.DefaultMaxIdleConnsPerHost is used.
 		MaxIdleConnsPerHost int
 
+		// MaxIdleConns, if non-zero, caps the idle (keep-alive)
+		// connections across all hosts.
+		MaxIdleConns int
+
+		// MaxConnsPerHost, if non-zero, caps the connections per host,
+		// including the ones in use. Requests over the limit wait for a
+		// connection.
+		MaxConnsPerHost int
+
+		// IdleConnTimeout, if non-zero, is how long an idle connection
+		// remains in the pool before it is closed.
+		IdleConnTimeout time.Duration
+
+		// EnableHTTP2, if true, negotiates HTTP/2 with the hosts that
+		// support it.
+		EnableHTTP2 bool
+
 		// ConnectTimeout, if non-zero, is the maximum amount of time a dial will wait for
 		// a connect to complete.
 		ConnectTimeout time.Duration
@@ -65,17 +87,91 @@ type (
 		// RequestTimeout, if non-zero, specifies the amount of time for the entire
 		// request to complete (including all of the above timeouts + entire response body).
 		// This should never be less than the sum total of the above two timeouts.
+		// It covers every retry, hedge and rate limit wait of the request.
+		// Use SetTimeouts to change the timeouts once requests are sent.
 		RequestTimeout time.Duration
 
-		starter   sync.Once
-		transport *http.Transport
+		// MaxResponseSize, if non-zero, is the maximum number of bytes
+		// accepted in a response body. Larger bodies are aborted with a
+		// *ResponseTooLargeError.
+		MaxResponseSize int64
+
+		// Compression, if non-nil, decodes gzip and deflate responses even
+		// when the caller sets its own Accept-Encoding and compresses large
+		// request bodies.
+		Compression *CompressionPolicy
+
+		// Retry, if non-nil, replays requests that fail with a transport error
+		// or a retryable status code. Only requests with an idempotent method
+		// or an Idempotency-Key header, and a replayable body, are retried.
+		Retry *RetryPolicy
+
+		// Breaker, if non-nil, enables a circuit breaker per upstream host so
+		// requests to a failing host are rejected instead of waiting out
+		// the timeouts.
+		Breaker *BreakerPolicy
+
+		// Interceptors wrap every round trip in the order specified. They
+		// run once per request, outside of the retry policy.
+		Interceptors []Interceptor
+
+		// RateLimit, if non-nil, limits the rate of requests sent to each
+		// host and pauses a host answering with 429.
+		RateLimit *RateLimitPolicy
+
+		// Hedge, if non-nil, duplicates slow idempotent requests and uses
+		// the first response.
+		Hedge *HedgePolicy
+
+		// Cache, if non-nil, stores GET responses and serves them according
+		// to Cache-Control, Expires, ETag and Last-Modified. Requests with an
+		// Authorization or Cookie header and private responses are never stored.
+		Cache CacheStore
+
+		// Metrics, if non-nil, collects the timings of every request sent
+		// to the upstream. Add the TraceParent interceptor to link them to
+		// the inbound request.
+		Metrics *Metrics
+
+		// Fixtures, if non-nil, records the interactions to a file or
+		// replays them without contacting the upstream, for tests.
+		Fixtures *Fixtures
+
+		// SigningKey, if set, signs every attempt of a request with the
+		// crypto package signature headers so the receiver can verify it
+		// with a crypto.RequestVerifier.
+		SigningKey string
+
+		// Auth, if non-nil, sets the token of the source on every request
+		// and refreshes it once when the upstream answers 401. Wrap the
+		// grants with NewCachedTokenSource so tokens are reused.
+		Auth TokenSource
+
+		// Logging controls how Do logs requests and responses. When nil the
+		// requests are logged at trace level without their bodies.
+		Logging *LogConfig
+
+		starter       sync.Once
+		certificates  *tlsManager
+		transport     *pooledTransport
+		transportLock sync.RWMutex
+		pool          poolTracker
+		breakers      map[string]*circuitBreaker
+		breakersLock  sync.Mutex
+		buckets       map[string]*tokenBucket
+		bucketsLock   sync.Mutex
+		hedges        map[string]*hedgeBudget
+		hedgesLock    sync.Mutex
 	}
 )
 
-// bodyCloseInterceptor.
+// bodyCloseInterceptor keeps the request deadline running until the
+// body has been read and closed.
 type bodyCloseInterceptor struct {
 	io.ReadCloser
-	timer *time.Timer
+	req    *http.Request
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // Version returns the current version of the package.
@@ -97,15 +193,25 @@ func NewTransport(proxy ProxyFunc) *Transport {
 
 // Get implements a get request.
 func (t *Transport) Get(url string) ([]byte, error) {
-	return t.GetWithHeaders(url, nil)
+	return t.GetWithHeadersContext(context.Background(), url, nil)
+}
+
+// GetContext implements a get request bound to the context.
+func (t *Transport) GetContext(ctx context.Context, url string) ([]byte, error) {
+	return t.GetWithHeadersContext(ctx, url, nil)
 }
 
 // Get performs a Get request with the specified headers.
 func (t *Transport) GetWithHeaders(url string, headers map[string]string) ([]byte, error) {
-	client := &http.Client{Transport: t}
-	req, err := http.NewRequest("GET", url, nil)
+	return t.GetWithHeadersContext(context.Background(), url, headers)
+}
+
+// GetWithHeadersContext performs a Get request with the specified headers
+// bound to the context.
+func (t *Transport) GetWithHeadersContext(ctx context.Context, url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		tracelog.ERROR(err, "http_client", "GetWithHeaders")
+		tracelog.ERROR(err, "http_client", "GetWithHeadersContext")
 		return nil, err
 	}
 
@@ -113,24 +219,35 @@ func (t *Transport) GetWithHeaders(url string, headers map[string]string) ([]byt
 		req.Header.Set(key, value)
 	}
 
-	resp, err := client.Do(req)
+	resp, err := t.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	return loadResponse(resp)
+
+	return resp.Body, nil
 }
 
 // Post performs a post request.
 func (t *Transport) Post(url string, postParams url.Values) ([]byte, error) {
-	return t.PostWithHeaders(url, postParams, nil)
+	return t.PostWithHeadersContext(context.Background(), url, postParams, nil)
+}
+
+// PostContext performs a post request bound to the context.
+func (t *Transport) PostContext(ctx context.Context, url string, postParams url.Values) ([]byte, error) {
+	return t.PostWithHeadersContext(ctx, url, postParams, nil)
 }
 
 // Post performs a post request with the specified headers.
 func (t *Transport) PostWithHeaders(url string, postParams url.Values, headers map[string]string) ([]byte, error) {
-	client := &http.Client{Transport: t}
-	req, err := http.NewRequest("POST", url, strings.NewReader(postParams.Encode()))
+	return t.PostWithHeadersContext(context.Background(), url, postParams, headers)
+}
+
+// PostWithHeadersContext performs a post request with the specified headers
+// bound to the context.
+func (t *Transport) PostWithHeadersContext(ctx context.Context, url string, postParams url.Values, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(postParams.Encode()))
 	if err != nil {
-		tracelog.ERROR(err, "http_client", "PostWithHeaders")
+		tracelog.ERROR(err, "http_client", "PostWithHeadersContext")
 		return nil, err
 	}
 
@@ -140,74 +257,258 @@ func (t *Transport) PostWithHeaders(url string, postParams url.Values, headers m
 		req.Header.Set(key, value)
 	}
 
+	resp, err := t.Do(req)
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "PostWithHeadersContext")
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Do executes the request and returns the full response. A response with
+// a non 2xx status is returned together with an *HTTPError. The request
+// context controls cancellation, see WithRequestTimeout to override the
+// transport's RequestTimeout for a single request.
+func (t *Transport) Do(req *http.Request) (*Response, error) {
+	if err := t.resolveRequest(req); err != nil {
+		tracelog.ERROR(err, "http_client", "Do")
+		return nil, err
+	}
+
+	client := &http.Client{Transport: t}
+
+	t.logConfig().logRequest(req)
+
+	started := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		tracelog.ERROR(err, "http_client", "PostWithHeaders")
 		return nil, err
 	}
 
-	return loadResponse(resp)
+	return t.loadResponse(req, resp, started)
 }
 
 // loadResponse parse a response.
-func loadResponse(resp *http.Response) ([]byte, error) {
+func (t *Transport) loadResponse(req *http.Request, resp *http.Response, started time.Time) (*Response, error) {
 	tracelog.STARTED("http_client", "loadResponse")
 
+	defer resp.Body.Close()
+
 	contents, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, contextError(req.Context(), req, err)
 	}
 
-	defer resp.Body.Close()
+	response := &Response{
+		StatusCode:  resp.StatusCode,
+		Status:      resp.Status,
+		Header:      resp.Header,
+		ContentType: resp.Header.Get("Content-Type"),
+		CacheStatus: resp.Header.Get(CACHE_STATUS_HEADER),
+		Body:        contents,
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		Started:     started,
+		Duration:    time.Since(started),
+	}
 
-	tracelog.INFO("yodlee_api", "loadResponse", "Api Response => \n\n %s \n\n", contents)
+	t.logConfig().logResponse(response)
 
-	if resp.StatusCode != 200 {
-		return nil, errors.New(string(contents))
+	if !response.IsSuccess() {
+		return response, newHTTPError(response.Method, response.URL, response.StatusCode, contents)
 	}
 
 	tracelog.COMPLETED("http_client", "loadResponse")
-	return contents, err
+	return response, nil
+}
+
+// RoundTrip implements the RoundTripper interface. The request timeout
+// applies to the whole call, including the retries and hedges.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, timeout := t.current()
+	if override, ok := requestTimeout(req.Context()); ok {
+		timeout = override
+	}
+
+	if timeout <= 0 {
+		return t.roundTripChain(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	timedReq := req.WithContext(ctx)
+
+	resp, err := t.roundTripChain(timedReq)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &bodyCloseInterceptor{ReadCloser: resp.Body, req: timedReq, ctx: ctx, cancel: cancel}
+	return resp, nil
+}
+
+// roundTripChain runs the request through the interceptors, the
+// authentication, the fixtures and the cache.
+func (t *Transport) roundTripChain(req *http.Request) (*http.Response, error) {
+	next := t.roundTrip
+	if t.Cache != nil {
+		next = t.cachedRoundTrip
+	}
+
+	if t.Fixtures != nil {
+		inner := next
+		next = func(req *http.Request) (*http.Response, error) {
+			return t.Fixtures.roundTrip(req, inner)
+		}
+	}
+
+	if t.Auth != nil {
+		inner := next
+		auth := OAuth2(t.Auth)
+		next = func(req *http.Request) (*http.Response, error) {
+			return auth(req, inner)
+		}
+	}
+
+	if len(t.Interceptors) > 0 {
+		return chain(t.Interceptors, next)(req)
+	}
+
+	return next(req)
 }
 
-// lazyStart.
-func (t *Transport) lazyStart() {
-	dialer := &net.Dialer{Timeout: t.ConnectTimeout}
-	t.transport = &http.Transport{
-		Dial:                  dialer.Dial,
-		Proxy:                 t.Proxy,
-		TLSClientConfig:       t.TLSClientConfig,
-		DisableKeepAlives:     t.DisableKeepAlives,
-		DisableCompression:    t.DisableCompression,
-		MaxIdleConnsPerHost:   t.MaxIdleConnsPerHost,
-		ResponseHeaderTimeout: t.ResponseHeaderTimeout,
+// roundTrip sends the request applying the retry policy when possible.
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.Compression != nil {
+		var err error
+		if req, err = t.Compression.prepareRequest(req); err != nil {
+			return nil, err
+		}
 	}
+
+	if t.Retry != nil && t.Retry.MaxAttempts > 1 && canReplay(req) {
+		return t.roundTripWithRetry(req)
+	}
+
+	return t.attempt(req)
+}
+
+// attempt sends a single attempt of the request, hedging it when possible.
+func (t *Transport) attempt(req *http.Request) (*http.Response, error) {
+	if t.canHedge(req) {
+		return t.roundTripHedged(req)
+	}
+
+	return t.roundTripOnce(req)
 }
 
-// RoundTrip implements the RoundTripper interface.
-func (t *Transport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
-	t.starter.Do(t.lazyStart)
+// roundTripOnce sends the request a single time through the rate limiter
+// and the circuit breaker.
+func (t *Transport) roundTripOnce(req *http.Request) (*http.Response, error) {
+	if err := t.waitRateLimit(req); err != nil {
+		return nil, err
+	}
 
-	if t.RequestTimeout > 0 {
-		timer := time.AfterFunc(t.RequestTimeout, func() {
-			t.transport.CancelRequest(req)
-		})
+	breaker := t.breakerFor(req.URL.Host)
+	if breaker != nil {
+		if err := breaker.allow(req.URL.Host); err != nil {
+			return nil, err
+		}
+	}
 
-		resp, err = t.transport.RoundTrip(req)
-		if err != nil {
-			timer.Stop()
+	resp, err := t.send(req)
+
+	if breaker != nil {
+		if isBreakerNeutral(err) {
+			breaker.release()
 		} else {
-			resp.Body = &bodyCloseInterceptor{ReadCloser: resp.Body, timer: timer}
+			breaker.record(req.URL.Host, isBreakerFailure(resp, err))
+		}
+	}
+
+	if err == nil {
+		t.slowDown(req, resp)
+	}
+
+	return resp, err
+}
+
+// send sends the request recording its metrics.
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	if t.SigningKey != "" {
+		var err error
+		if req, err = t.signRequest(req); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.Metrics == nil {
+		return t.transmit(req)
+	}
+
+	tracedReq, trace := t.Metrics.trace(req)
+	resp, err := t.transmit(tracedReq)
+	return t.Metrics.finish(req, trace, resp, err)
+}
+
+// transmit sends the request through the http transport in use.
+func (t *Transport) transmit(req *http.Request) (*http.Response, error) {
+	if t.certificates != nil {
+		t.certificates.reloadIfChanged(t)
+	}
+
+	transport, _ := t.current()
+	transport.acquire()
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		transport.release()
+		return nil, t.sendError(req.Context(), req, err)
+	}
+
+	return t.receive(req, resp, transport)
+}
+
+// receive tracks the response in the pool stats, applies the size limits
+// and decodes its body.
+func (t *Transport) receive(req *http.Request, resp *http.Response, transport *pooledTransport) (*http.Response, error) {
+	resp, err := t.limitResponse(req, t.pool.track(resp, transport))
+	if err != nil {
+		return nil, err
+	}
+
+	return t.decodeResponse(req, resp)
+}
+
+// sendError converts the error of a round trip into the typed errors of
+// the package.
+func (t *Transport) sendError(ctx context.Context, req *http.Request, err error) error {
+	if pinErr, ok := pinMismatch(err); ok {
+		return pinErr
+	}
+
+	if ctx.Err() == nil {
+		if proxyErr, ok := t.proxyError(req, err); ok {
+			return proxyErr
 		}
-	} else {
-		resp, err = t.transport.RoundTrip(req)
 	}
 
-	return
+	return contextError(ctx, req, err)
+}
+
+// Read reports reads aborted by the request deadline as a ContextError.
+func (bci *bodyCloseInterceptor) Read(p []byte) (int, error) {
+	n, err := bci.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = contextError(bci.ctx, bci.req, err)
+	}
+
+	return n, err
 }
 
 // Close.
 func (bci *bodyCloseInterceptor) Close() error {
-	bci.timer.Stop()
+	bci.cancel()
 	return bci.ReadCloser.Close()
 }
@@ -0,0 +1,139 @@
+package httpClient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ArdanStudios/go-common/uuid"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-Id"
+)
+
+type (
+	// RoundTripFunc is a single step of the interceptor chain.
+	RoundTripFunc func(*http.Request) (*http.Response, error)
+
+	// Interceptor wraps the round trip of a request. It calls next to
+	// continue the chain or returns its own response to short-circuit it.
+	// Interceptors must not modify the request they receive, use
+	// CloneRequest to change headers.
+	Interceptor func(req *http.Request, next RoundTripFunc) (*http.Response, error)
+
+	// requestIDKey is the context key holding the request id.
+	requestIDKey struct{}
+)
+
+// WithRequestID returns a context carrying the request id to propagate
+// to outbound calls by the RequestID interceptor.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request id stored in the context, if any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey{}).(string)
+	return requestID, ok && requestID != ""
+}
+
+// CloneRequest returns a shallow copy of the request with its own headers.
+func CloneRequest(req *http.Request) *http.Request {
+	newReq := new(http.Request)
+	*newReq = *req
+	newReq.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		newReq.Header[key] = append([]string(nil), values...)
+	}
+
+	return newReq
+}
+
+// SyntheticResponse builds a response for the request without contacting
+// the upstream, used by interceptors that short-circuit the chain.
+func SyntheticResponse(req *http.Request, statusCode int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// StaticHeaders sets the specified headers on every request.
+func StaticHeaders(headers map[string]string) Interceptor {
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		req = CloneRequest(req)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+
+		return next(req)
+	}
+}
+
+// BearerToken sets the Authorization header to the bearer token.
+func BearerToken(token string) Interceptor {
+	return StaticHeaders(map[string]string{"Authorization": "Bearer " + token})
+}
+
+// UserAgent sets the User-Agent header on every request.
+func UserAgent(userAgent string) Interceptor {
+	return StaticHeaders(map[string]string{"User-Agent": userAgent})
+}
+
+// RequestID propagates the request id found in the request context in the
+// specified header, REQUEST_ID_HEADER is used when blank. A new id is
+// generated when the context does not carry one and the request does not
+// already have the header.
+func RequestID(header string) Interceptor {
+	if header == "" {
+		header = REQUEST_ID_HEADER
+	}
+
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		requestID, ok := RequestIDFromContext(req.Context())
+		if !ok {
+			if req.Header.Get(header) != "" {
+				return next(req)
+			}
+
+			id, err := uuid.NewV4()
+			if err != nil {
+				return nil, err
+			}
+			requestID = id.String()
+		}
+
+		req = CloneRequest(req)
+		req.Header.Set(header, requestID)
+		return next(req)
+	}
+}
+
+// chain builds the round trip function running the interceptors in order
+// around last.
+func chain(interceptors []Interceptor, last RoundTripFunc) RoundTripFunc {
+	next := last
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor := interceptors[i]
+		inner := next
+		next = func(req *http.Request) (*http.Response, error) {
+			return interceptor(req, inner)
+		}
+	}
+
+	return next
+}
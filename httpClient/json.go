@@ -0,0 +1,141 @@
+package httpClient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/ArdanStudios/go-common/decoder"
+	"github.com/goinggo/tracelog"
+)
+
+type (
+	// JSONSlice is used as a target when the response can either be an array
+	// or a single object. Slice receives the documents when the response is
+	// an array and Object receives the document otherwise.
+	JSONSlice struct {
+		Slice   interface{}
+		Object  interface{}
+		IsArray bool
+	}
+)
+
+// GetJSON performs a get request and decodes the response into target.
+func (t *Transport) GetJSON(url string, target interface{}) error {
+	return t.GetJSONContext(context.Background(), url, target)
+}
+
+// GetJSONContext performs a get request bound to the context and decodes
+// the response into target.
+func (t *Transport) GetJSONContext(ctx context.Context, url string, target interface{}) error {
+	_, err := t.DoJSON(ctx, "GET", url, nil, target)
+	return err
+}
+
+// PostJSON posts body as a JSON document and decodes the response into target.
+func (t *Transport) PostJSON(url string, body interface{}, target interface{}) error {
+	return t.PostJSONContext(context.Background(), url, body, target)
+}
+
+// PostJSONContext posts body as a JSON document bound to the context and
+// decodes the response into target.
+func (t *Transport) PostJSONContext(ctx context.Context, url string, body interface{}, target interface{}) error {
+	_, err := t.DoJSON(ctx, "POST", url, body, target)
+	return err
+}
+
+// PutJSON puts body as a JSON document and decodes the response into target.
+func (t *Transport) PutJSON(url string, body interface{}, target interface{}) error {
+	return t.PutJSONContext(context.Background(), url, body, target)
+}
+
+// PutJSONContext puts body as a JSON document bound to the context and
+// decodes the response into target.
+func (t *Transport) PutJSONContext(ctx context.Context, url string, body interface{}, target interface{}) error {
+	_, err := t.DoJSON(ctx, "PUT", url, body, target)
+	return err
+}
+
+// PatchJSON patches body as a JSON document and decodes the response into target.
+func (t *Transport) PatchJSON(url string, body interface{}, target interface{}) error {
+	return t.PatchJSONContext(context.Background(), url, body, target)
+}
+
+// PatchJSONContext patches body as a JSON document bound to the context and
+// decodes the response into target.
+func (t *Transport) PatchJSONContext(ctx context.Context, url string, body interface{}, target interface{}) error {
+	_, err := t.DoJSON(ctx, "PATCH", url, body, target)
+	return err
+}
+
+// DeleteJSON performs a delete request and decodes the response into target.
+func (t *Transport) DeleteJSON(url string, target interface{}) error {
+	return t.DeleteJSONContext(context.Background(), url, target)
+}
+
+// DeleteJSONContext performs a delete request bound to the context and
+// decodes the response into target.
+func (t *Transport) DeleteJSONContext(ctx context.Context, url string, target interface{}) error {
+	_, err := t.DoJSON(ctx, "DELETE", url, nil, target)
+	return err
+}
+
+// DoJSON sends body, if not nil, as a JSON document and decodes the response
+// into target, if not nil. The target is decoded with the decoder package so
+// the jpath tags are honored. Use a *JSONSlice target when the response can
+// be either an array or an object.
+func (t *Transport) DoJSON(ctx context.Context, method string, url string, body interface{}, target interface{}) (*Response, error) {
+	var reader io.Reader
+	if body != nil {
+		doc, err := json.Marshal(body)
+		if err != nil {
+			tracelog.ERROR(err, "http_client", "DoJSON, Marshal Body")
+			return nil, err
+		}
+		reader = bytes.NewReader(doc)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "DoJSON")
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.Do(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if err := decodeJSON(resp.Body, target); err != nil {
+		return resp, err
+	}
+
+	return resp, nil
+}
+
+// decodeJSON decodes the document into target using the decoder package.
+func decodeJSON(doc []byte, target interface{}) error {
+	if target == nil || len(bytes.TrimSpace(doc)) == 0 {
+		return nil
+	}
+
+	if slice, ok := target.(*JSONSlice); ok {
+		isArray, err := decoder.DecodeSlice(doc, slice.Slice, slice.Object)
+		slice.IsArray = isArray
+		return err
+	}
+
+	if decoder.IsArrayResponse(doc) {
+		_, err := decoder.DecodeSlice(doc, target, nil)
+		return err
+	}
+
+	return decoder.Decode(doc, target)
+}
@@ -0,0 +1,207 @@
+package httpClient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	REDACTED_VALUE = "[REDACTED]"
+)
+
+type (
+	// LogConfig controls how requests and responses are logged by Do.
+	LogConfig struct {
+		// Component is the routine name the entries are logged under.
+		Component string
+
+		// Level is the tracelog level used for the entries, one of
+		// LEVEL_TRACE, LEVEL_INFO or LEVEL_WARN. LEVEL_OFF disables logging.
+		Level int32
+
+		// LogBodies, if true, adds the request and response bodies to
+		// the entries.
+		LogBodies bool
+
+		// MaxBodyBytes, if non-zero, caps the number of body bytes logged.
+		MaxBodyBytes int
+
+		// RedactHeaders lists the headers whose values are never logged.
+		RedactHeaders []string
+
+		// RedactFields lists the JSON fields whose values are never logged,
+		// at any depth of the document.
+		RedactFields []string
+	}
+)
+
+// NewLogConfig creates a log configuration for the component that logs at
+// trace level without bodies and redacts the credential headers.
+func NewLogConfig(component string) *LogConfig {
+	return &LogConfig{
+		Component:     component,
+		Level:         tracelog.LEVEL_TRACE,
+		MaxBodyBytes:  2048,
+		RedactHeaders: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+	}
+}
+
+// defaultLogConfig is used when the transport has no log configuration.
+var defaultLogConfig = NewLogConfig("http_client")
+
+// logConfig returns the configuration in use for the transport.
+func (t *Transport) logConfig() *LogConfig {
+	if t.Logging == nil {
+		return defaultLogConfig
+	}
+
+	return t.Logging
+}
+
+// logRequest writes the request to the log.
+func (lc *LogConfig) logRequest(req *http.Request) {
+	if lc.Level == tracelog.LEVEL_OFF {
+		return
+	}
+
+	var body []byte
+	if lc.LogBodies && req.GetBody != nil {
+		if reader, err := req.GetBody(); err == nil {
+			body, _ = ioutil.ReadAll(reader)
+			reader.Close()
+		}
+	}
+
+	lc.log("logRequest", "Api Request => %s %s\n%s\n\n %s \n\n", req.Method, req.URL, lc.formatHeaders(req.Header), lc.formatBody(req.Header, body))
+}
+
+// logResponse writes the response to the log.
+func (lc *LogConfig) logResponse(response *Response) {
+	if lc.Level == tracelog.LEVEL_OFF {
+		return
+	}
+
+	var body []byte
+	if lc.LogBodies {
+		body = response.Body
+	}
+
+	lc.log("logResponse", "Api Response => %s %s : %s [%v]\n%s\n\n %s \n\n", response.Method, response.URL, response.Status, response.Duration, lc.formatHeaders(response.Header), lc.formatBody(response.Header, body))
+}
+
+// log writes the entry at the configured level.
+func (lc *LogConfig) log(functionName string, format string, a ...interface{}) {
+	component := lc.Component
+	if component == "" {
+		component = "http_client"
+	}
+
+	switch lc.Level {
+	case tracelog.LEVEL_TRACE:
+		tracelog.TRACE(component, functionName, format, a...)
+	case tracelog.LEVEL_INFO:
+		tracelog.INFO(component, functionName, format, a...)
+	case tracelog.LEVEL_WARN:
+		tracelog.WARN(component, functionName, format, a...)
+	}
+}
+
+// formatHeaders renders the headers sorted by name with redacted values.
+func (lc *LogConfig) formatHeaders(header http.Header) string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		value := strings.Join(header[key], ", ")
+		if containsFold(lc.RedactHeaders, key) {
+			value = REDACTED_VALUE
+		}
+		fmt.Fprintf(&buf, "%s: %s\n", key, value)
+	}
+
+	return buf.String()
+}
+
+// formatBody redacts and truncates a body for logging.
+func (lc *LogConfig) formatBody(header http.Header, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	if len(lc.RedactFields) > 0 && strings.Contains(header.Get("Content-Type"), "json") {
+		body = lc.redactJSON(body)
+	}
+
+	if lc.MaxBodyBytes > 0 && len(body) > lc.MaxBodyBytes {
+		return fmt.Sprintf("%s... [%d bytes truncated]", body[:lc.MaxBodyBytes], len(body)-lc.MaxBodyBytes)
+	}
+
+	return string(body)
+}
+
+// redactJSON replaces the value of the redacted fields in the document.
+// A document that can't be parsed is not logged at all.
+func (lc *LogConfig) redactJSON(body []byte) []byte {
+	return redactJSONFields(body, lc.RedactFields)
+}
+
+// redactJSONFields replaces the value of the fields, at any depth of the
+// document. A document that can't be parsed is replaced as a whole.
+func redactJSONFields(body []byte, fields []string) []byte {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return []byte(REDACTED_VALUE)
+	}
+
+	doc = redactValue(doc, fields)
+
+	redacted, err := json.Marshal(doc)
+	if err != nil {
+		return []byte(REDACTED_VALUE)
+	}
+
+	return redacted
+}
+
+// redactValue walks the document redacting the fields.
+func redactValue(value interface{}, fields []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if containsFold(fields, key) {
+				v[key] = REDACTED_VALUE
+				continue
+			}
+			v[key] = redactValue(field, fields)
+		}
+
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item, fields)
+		}
+	}
+
+	return value
+}
+
+// containsFold checks if the value is in the list ignoring case.
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
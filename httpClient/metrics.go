@@ -0,0 +1,307 @@
+package httpClient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+type (
+	// RequestTiming holds the phases of a single request.
+	RequestTiming struct {
+		DNS             time.Duration
+		Connect         time.Duration
+		TLSHandshake    time.Duration
+		TimeToFirstByte time.Duration
+		Total           time.Duration
+		ReusedConn      bool
+	}
+
+	// RequestMetric describes a request sent to the upstream.
+	RequestMetric struct {
+		Method      string
+		URL         string
+		Host        string
+		StatusCode  int
+		StatusClass string
+		Err         error
+		TraceID     string
+		SpanID      string
+		Timing      RequestTiming
+	}
+
+	// MetricSeries aggregates the requests of a host and status class.
+	MetricSeries struct {
+		Host        string
+		StatusClass string
+
+		Count             int64
+		ReusedConnections int64
+
+		// The phase durations are summed over all the requests
+		DNS             time.Duration
+		Connect         time.Duration
+		TLSHandshake    time.Duration
+		TimeToFirstByte time.Duration
+		Total           time.Duration
+
+		// Buckets holds the upper bounds in seconds of the total duration
+		// histogram and BucketCounts the number of requests in each bucket.
+		Buckets      []float64
+		BucketCounts []int64
+	}
+
+	// MetricsExporter writes a snapshot of the metrics in its own format.
+	MetricsExporter interface {
+		Export(w io.Writer, snapshot []MetricSeries) error
+	}
+
+	// Metrics collects the timings of the requests sent by the transport.
+	Metrics struct {
+		// Buckets holds the upper bounds in seconds of the duration
+		// histogram. It must not change once requests are observed.
+		Buckets []float64
+
+		// OnRequest, if non-nil, is called after each request completes.
+		OnRequest func(*RequestMetric)
+
+		lock   sync.Mutex
+		series map[string]*MetricSeries
+	}
+
+	// requestTrace records the httptrace events of a request.
+	requestTrace struct {
+		lock         sync.Mutex
+		started      time.Time
+		dnsStart     time.Time
+		connectStart time.Time
+		tlsStart     time.Time
+		timing       RequestTiming
+	}
+
+	// metricsBody records the metric once the body is closed.
+	metricsBody struct {
+		io.ReadCloser
+		once   sync.Once
+		finish func()
+	}
+)
+
+// NewMetrics creates a collector with the default latency buckets.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}
+}
+
+// statusClass returns the class of the status code, or error when the
+// request failed.
+func statusClass(statusCode int, err error) string {
+	if err != nil || statusCode < 100 || statusCode > 599 {
+		return "error"
+	}
+
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
+
+// Snapshot returns a copy of the aggregated series sorted by host and class.
+func (m *Metrics) Snapshot() []MetricSeries {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	snapshot := make([]MetricSeries, 0, len(m.series))
+	for _, series := range m.series {
+		copied := *series
+		copied.Buckets = append([]float64(nil), series.Buckets...)
+		copied.BucketCounts = append([]int64(nil), series.BucketCounts...)
+		snapshot = append(snapshot, copied)
+	}
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Host != snapshot[j].Host {
+			return snapshot[i].Host < snapshot[j].Host
+		}
+		return snapshot[i].StatusClass < snapshot[j].StatusClass
+	})
+
+	return snapshot
+}
+
+// Export writes a snapshot of the metrics with the exporter.
+func (m *Metrics) Export(w io.Writer, exporter MetricsExporter) error {
+	return exporter.Export(w, m.Snapshot())
+}
+
+// Handler returns an http handler serving the metrics with the exporter.
+// The metrics are exported before anything is written so a failed export
+// is answered with a 500 instead of a truncated page.
+func (m *Metrics) Handler(exporter MetricsExporter, contentType string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := m.Export(&buf, exporter); err != nil {
+			tracelog.ERROR(err, "http_client", "Metrics.Handler")
+			http.Error(w, "Unable To Export Metrics", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		if _, err := buf.WriteTo(w); err != nil {
+			tracelog.ERROR(err, "http_client", "Metrics.Handler")
+		}
+	})
+}
+
+// record adds the metric to its series and calls the hook.
+func (m *Metrics) record(metric *RequestMetric) {
+	key := metric.Host + " " + metric.StatusClass
+	seconds := metric.Timing.Total.Seconds()
+
+	m.lock.Lock()
+	if m.series == nil {
+		m.series = map[string]*MetricSeries{}
+	}
+
+	series := m.series[key]
+	if series == nil {
+		series = &MetricSeries{
+			Host:         metric.Host,
+			StatusClass:  metric.StatusClass,
+			Buckets:      m.Buckets,
+			BucketCounts: make([]int64, len(m.Buckets)),
+		}
+		m.series[key] = series
+	}
+
+	series.Count++
+	if metric.Timing.ReusedConn {
+		series.ReusedConnections++
+	}
+	series.DNS += metric.Timing.DNS
+	series.Connect += metric.Timing.Connect
+	series.TLSHandshake += metric.Timing.TLSHandshake
+	series.TimeToFirstByte += metric.Timing.TimeToFirstByte
+	series.Total += metric.Timing.Total
+
+	for i, bound := range series.Buckets {
+		if seconds <= bound {
+			series.BucketCounts[i]++
+			break
+		}
+	}
+	m.lock.Unlock()
+
+	if m.OnRequest != nil {
+		m.OnRequest(metric)
+	}
+}
+
+// trace attaches an httptrace to the request.
+func (m *Metrics) trace(req *http.Request) (*http.Request, *requestTrace) {
+	rt := &requestTrace{started: time.Now()}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), rt.clientTrace())), rt
+}
+
+// finish records the metric once the response body is closed, or right
+// away when the request failed.
+func (m *Metrics) finish(req *http.Request, rt *requestTrace, resp *http.Response, err error) (*http.Response, error) {
+	metric := &RequestMetric{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Host:   req.URL.Host,
+		Err:    err,
+	}
+
+	if traceID, spanID, ok := parseTraceparent(req.Header.Get(TRACEPARENT_HEADER)); ok {
+		metric.TraceID = traceID
+		metric.SpanID = spanID
+	}
+
+	if err != nil {
+		metric.StatusClass = statusClass(0, err)
+		metric.Timing = rt.complete()
+		m.record(metric)
+		return resp, err
+	}
+
+	metric.StatusCode = resp.StatusCode
+	metric.StatusClass = statusClass(resp.StatusCode, nil)
+	resp.Body = &metricsBody{
+		ReadCloser: resp.Body,
+		finish: func() {
+			metric.Timing = rt.complete()
+			m.record(metric)
+		},
+	}
+
+	return resp, nil
+}
+
+// clientTrace returns the hooks recording the phases.
+func (rt *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			rt.lock.Lock()
+			rt.dnsStart = time.Now()
+			rt.lock.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			rt.lock.Lock()
+			rt.timing.DNS = time.Since(rt.dnsStart)
+			rt.lock.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			rt.lock.Lock()
+			rt.connectStart = time.Now()
+			rt.lock.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			rt.lock.Lock()
+			rt.timing.Connect = time.Since(rt.connectStart)
+			rt.lock.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			rt.lock.Lock()
+			rt.tlsStart = time.Now()
+			rt.lock.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			rt.lock.Lock()
+			rt.timing.TLSHandshake = time.Since(rt.tlsStart)
+			rt.lock.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			rt.lock.Lock()
+			rt.timing.ReusedConn = info.Reused
+			rt.lock.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			rt.lock.Lock()
+			rt.timing.TimeToFirstByte = time.Since(rt.started)
+			rt.lock.Unlock()
+		},
+	}
+}
+
+// complete returns the timing with the total duration.
+func (rt *requestTrace) complete() RequestTiming {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	rt.timing.Total = time.Since(rt.started)
+	return rt.timing
+}
+
+// Close records the metric and closes the body.
+func (mb *metricsBody) Close() error {
+	err := mb.ReadCloser.Close()
+	mb.once.Do(mb.finish)
+	return err
+}
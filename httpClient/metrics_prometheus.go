@@ -0,0 +1,93 @@
+package httpClient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PROMETHEUS_CONTENT_TYPE = "text/plain; version=0.0.4"
+)
+
+type (
+	// PrometheusExporter writes the metrics in the Prometheus text format.
+	PrometheusExporter struct {
+		// Namespace prefixes the metric names, http_client when blank.
+		Namespace string
+	}
+)
+
+// Export writes the snapshot in the Prometheus text format.
+func (pe *PrometheusExporter) Export(w io.Writer, snapshot []MetricSeries) error {
+	namespace := pe.Namespace
+	if namespace == "" {
+		namespace = "http_client"
+	}
+
+	buf := bufio.NewWriter(w)
+
+	counter := func(name string, help string, value func(*MetricSeries) string) {
+		fmt.Fprintf(buf, "# HELP %s_%s %s\n", namespace, name, help)
+		fmt.Fprintf(buf, "# TYPE %s_%s counter\n", namespace, name)
+		for i := range snapshot {
+			fmt.Fprintf(buf, "%s_%s{%s} %s\n", namespace, name, seriesLabels(&snapshot[i]), value(&snapshot[i]))
+		}
+	}
+
+	counter("requests_total", "Requests sent by host and status class.", func(s *MetricSeries) string {
+		return strconv.FormatInt(s.Count, 10)
+	})
+	counter("connections_reused_total", "Requests sent on a reused connection.", func(s *MetricSeries) string {
+		return strconv.FormatInt(s.ReusedConnections, 10)
+	})
+	counter("dns_seconds_total", "Time spent resolving host names.", func(s *MetricSeries) string {
+		return formatSeconds(s.DNS)
+	})
+	counter("connect_seconds_total", "Time spent establishing connections.", func(s *MetricSeries) string {
+		return formatSeconds(s.Connect)
+	})
+	counter("tls_handshake_seconds_total", "Time spent in TLS handshakes.", func(s *MetricSeries) string {
+		return formatSeconds(s.TLSHandshake)
+	})
+	counter("time_to_first_byte_seconds_total", "Time spent waiting for the first response byte.", func(s *MetricSeries) string {
+		return formatSeconds(s.TimeToFirstByte)
+	})
+
+	fmt.Fprintf(buf, "# HELP %s_request_duration_seconds Total duration of the requests.\n", namespace)
+	fmt.Fprintf(buf, "# TYPE %s_request_duration_seconds histogram\n", namespace)
+	for i := range snapshot {
+		series := &snapshot[i]
+		labels := seriesLabels(series)
+
+		cumulative := int64(0)
+		for index, bound := range series.Buckets {
+			cumulative += series.BucketCounts[index]
+			fmt.Fprintf(buf, "%s_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", namespace, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+
+		fmt.Fprintf(buf, "%s_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", namespace, labels, series.Count)
+		fmt.Fprintf(buf, "%s_request_duration_seconds_sum{%s} %s\n", namespace, labels, formatSeconds(series.Total))
+		fmt.Fprintf(buf, "%s_request_duration_seconds_count{%s} %d\n", namespace, labels, series.Count)
+	}
+
+	return buf.Flush()
+}
+
+// seriesLabels renders the labels of the series.
+func seriesLabels(series *MetricSeries) string {
+	return fmt.Sprintf(`host="%s",status_class="%s"`, escapeLabel(series.Host), escapeLabel(series.StatusClass))
+}
+
+// escapeLabel escapes a label value for the text format.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatSeconds renders a duration as seconds.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
@@ -0,0 +1,339 @@
+package httpClient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	// defaultExpiryDelta is how long before its expiry a token is refreshed.
+	defaultExpiryDelta = 30 * time.Second
+)
+
+var (
+	// tokenClient calls the token endpoints of the grants without a Client
+	// so the fetches share a single connection pool.
+	tokenClient = NewTransport(nil)
+)
+
+type (
+	// Token is an OAuth2 access token.
+	Token struct {
+		AccessToken  string
+		TokenType    string
+		RefreshToken string
+		Expiry       time.Time
+	}
+
+	// TokenSource returns the token to use for a request.
+	TokenSource interface {
+		Token(ctx context.Context) (*Token, error)
+	}
+
+	// ClientCredentials fetches tokens with the client credentials grant.
+	ClientCredentials struct {
+		TokenURL     string
+		ClientID     string
+		ClientSecret string
+		Scopes       []string
+
+		// Params holds additional parameters sent to the token endpoint.
+		Params url.Values
+
+		// AuthInParams, if true, sends the client credentials in the form
+		// instead of the basic authorization header.
+		AuthInParams bool
+
+		// Client, if non-nil, is the transport used to call the token endpoint.
+		// A transport shared by the grants is used when nil.
+		Client *Transport
+	}
+
+	// RefreshToken fetches tokens with the refresh token grant. The refresh
+	// token is replaced when the endpoint rotates it.
+	RefreshToken struct {
+		TokenURL     string
+		ClientID     string
+		ClientSecret string
+		Refresh      string
+
+		// AuthInParams, if true, sends the client credentials in the form
+		// instead of the basic authorization header.
+		AuthInParams bool
+
+		// Client, if non-nil, is the transport used to call the token endpoint.
+		// A transport shared by the grants is used when nil.
+		Client *Transport
+
+		lock sync.Mutex
+	}
+
+	// CachedTokenSource caches the token of a source until shortly before
+	// it expires. Concurrent callers share a single fetch.
+	CachedTokenSource struct {
+		Source TokenSource
+
+		// ExpiryDelta is how long before its expiry a token is refreshed.
+		ExpiryDelta time.Duration
+
+		lock  sync.Mutex
+		token *Token
+		fetch *tokenFetch
+	}
+
+	// TokenError is returned when the token endpoint rejects the request.
+	TokenError struct {
+		StatusCode  int
+		Code        string
+		Description string
+	}
+
+	// tokenFetch is a token request shared by concurrent callers.
+	tokenFetch struct {
+		done  chan struct{}
+		token *Token
+		err   error
+	}
+
+	// tokenResponse is the document returned by the token endpoint.
+	tokenResponse struct {
+		AccessToken      string `json:"access_token"`
+		TokenType        string `json:"token_type"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+)
+
+// NewCachedTokenSource creates a cache in front of the source.
+func NewCachedTokenSource(source TokenSource) *CachedTokenSource {
+	return &CachedTokenSource{Source: source, ExpiryDelta: defaultExpiryDelta}
+}
+
+// Error returns the error message of the token endpoint.
+func (e *TokenError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("token request failed : status[%d] : %s", e.StatusCode, e.Code)
+	}
+
+	return fmt.Sprintf("token request failed : status[%d] : %s : %s", e.StatusCode, e.Code, e.Description)
+}
+
+// valid checks if the token can be used for the specified time.
+func (tk *Token) valid(delta time.Duration) bool {
+	if tk == nil || tk.AccessToken == "" {
+		return false
+	}
+
+	return tk.Expiry.IsZero() || time.Now().Add(delta).Before(tk.Expiry)
+}
+
+// Token fetches a token with the client credentials grant.
+func (cc *ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	params := url.Values{"grant_type": {"client_credentials"}}
+	if len(cc.Scopes) > 0 {
+		params.Set("scope", strings.Join(cc.Scopes, " "))
+	}
+	for key, values := range cc.Params {
+		params[key] = values
+	}
+
+	return requestToken(ctx, cc.Client, cc.TokenURL, cc.ClientID, cc.ClientSecret, cc.AuthInParams, params)
+}
+
+// Token fetches a token with the refresh token grant.
+func (rt *RefreshToken) Token(ctx context.Context) (*Token, error) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	params := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {rt.Refresh},
+	}
+
+	token, err := requestToken(ctx, rt.Client, rt.TokenURL, rt.ClientID, rt.ClientSecret, rt.AuthInParams, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.RefreshToken != "" {
+		rt.Refresh = token.RefreshToken
+	}
+
+	return token, nil
+}
+
+// Token returns the cached token or fetches a new one.
+func (cts *CachedTokenSource) Token(ctx context.Context) (*Token, error) {
+	cts.lock.Lock()
+	if cts.token.valid(cts.ExpiryDelta) {
+		token := cts.token
+		cts.lock.Unlock()
+		return token, nil
+	}
+
+	fetch := cts.fetch
+	if fetch == nil {
+		fetch = &tokenFetch{done: make(chan struct{})}
+		cts.fetch = fetch
+
+		// The fetch is shared so it must not be cancelled by the first caller
+		go cts.run(fetch)
+	}
+	cts.lock.Unlock()
+
+	select {
+	case <-fetch.done:
+		return fetch.token, fetch.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate drops the cached token if it is still the specified one, so
+// the next call fetches a new token.
+func (cts *CachedTokenSource) Invalidate(token *Token) {
+	cts.lock.Lock()
+	defer cts.lock.Unlock()
+
+	if token == nil || cts.token == token {
+		cts.token = nil
+	}
+}
+
+// run fetches the token and publishes it to the waiting callers.
+func (cts *CachedTokenSource) run(fetch *tokenFetch) {
+	fetch.token, fetch.err = cts.Source.Token(context.Background())
+
+	cts.lock.Lock()
+	if fetch.err == nil {
+		cts.token = fetch.token
+	}
+	cts.fetch = nil
+	cts.lock.Unlock()
+
+	close(fetch.done)
+}
+
+// OAuth2 sets the bearer token of the source on every request. When the
+// upstream answers 401 the token is refreshed and the request is sent once
+// more, if its body can be replayed.
+func OAuth2(source TokenSource) Interceptor {
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		token, err := source.Token(req.Context())
+		if err != nil {
+			tracelog.ERROR(err, "http_client", "OAuth2")
+			return nil, err
+		}
+
+		resp, err := next(authorize(req, token))
+		if err != nil || resp.StatusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, nil
+		}
+
+		retryReq, err := rewindRequest(req)
+		if err != nil {
+			return resp, nil
+		}
+
+		drainBody(resp)
+		if cached, ok := source.(*CachedTokenSource); ok {
+			cached.Invalidate(token)
+		}
+
+		tracelog.TRACE("http_client", "OAuth2", "Refreshing Token Url[%s]", req.URL)
+		if token, err = source.Token(req.Context()); err != nil {
+			tracelog.ERROR(err, "http_client", "OAuth2")
+			return nil, err
+		}
+
+		return next(authorize(retryReq, token))
+	}
+}
+
+// authorize returns a copy of the request with the authorization header.
+func authorize(req *http.Request, token *Token) *http.Request {
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+
+	req = CloneRequest(req)
+	req.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return req
+}
+
+// requestToken posts the grant to the token endpoint.
+func requestToken(ctx context.Context, client *Transport, tokenURL string, clientID string, clientSecret string, authInParams bool, params url.Values) (*Token, error) {
+	if client == nil {
+		client = tokenClient
+	}
+
+	if authInParams {
+		params.Set("client_id", clientID)
+		if clientSecret != "" {
+			params.Set("client_secret", clientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !authInParams {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if resp == nil {
+		tracelog.ERROR(err, "http_client", "requestToken")
+		return nil, err
+	}
+
+	doc := tokenResponse{}
+	jsonErr := json.Unmarshal(resp.Body, &doc)
+
+	if err != nil || doc.Error != "" {
+		err = &TokenError{StatusCode: resp.StatusCode, Code: doc.Error, Description: doc.ErrorDescription}
+		tracelog.ERROR(err, "http_client", "requestToken")
+		return nil, err
+	}
+
+	if jsonErr != nil {
+		tracelog.ERROR(jsonErr, "http_client", "requestToken")
+		return nil, jsonErr
+	}
+
+	if doc.AccessToken == "" {
+		return nil, errors.New("No Access Token In Token Response")
+	}
+
+	token := &Token{
+		AccessToken:  doc.AccessToken,
+		TokenType:    doc.TokenType,
+		RefreshToken: doc.RefreshToken,
+	}
+
+	if doc.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(doc.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,198 @@
+package httpClient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenEndpoint stands in for an OAuth2 token endpoint issuing numbered tokens.
+func tokenEndpoint(t *testing.T, fetches *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Parsing Form : %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "client" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"Unknown Client"}`)
+			return
+		}
+
+		switch r.PostForm.Get("grant_type") {
+		case "client_credentials":
+			if scope := r.PostForm.Get("scope"); scope != "read write" {
+				t.Errorf("Scope[%s] Expected[read write]", scope)
+			}
+
+		case "refresh_token":
+			if refresh := r.PostForm.Get("refresh_token"); refresh != "refresh-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_grant"}`)
+				return
+			}
+
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"unsupported_grant_type"}`)
+			return
+		}
+
+		// Leave time for concurrent callers to pile up on the fetch
+		time.Sleep(20 * time.Millisecond)
+
+		fetch := atomic.AddInt32(fetches, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","refresh_token":"refresh-2","expires_in":3600}`, fetch)
+	}))
+}
+
+func TestClientCredentials(t *testing.T) {
+	var fetches int32
+	server := tokenEndpoint(t, &fetches)
+	defer server.Close()
+
+	source := &ClientCredentials{
+		TokenURL:     server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	}
+
+	token, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token : %v", err)
+	}
+
+	if token.AccessToken != "token-1" || token.TokenType != "bearer" {
+		t.Errorf("Token[%s] Type[%s] Expected[token-1] Type[bearer]", token.AccessToken, token.TokenType)
+	}
+
+	if !token.valid(time.Hour - time.Minute) {
+		t.Errorf("Expiry[%v] Expected In An Hour", token.Expiry)
+	}
+}
+
+func TestRefreshTokenRotates(t *testing.T) {
+	var fetches int32
+	server := tokenEndpoint(t, &fetches)
+	defer server.Close()
+
+	source := &RefreshToken{
+		TokenURL:     server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Refresh:      "refresh-1",
+	}
+
+	if _, err := source.Token(context.Background()); err != nil {
+		t.Fatalf("Token : %v", err)
+	}
+
+	if source.Refresh != "refresh-2" {
+		t.Errorf("Refresh[%s] Expected[refresh-2]", source.Refresh)
+	}
+}
+
+func TestTokenError(t *testing.T) {
+	var fetches int32
+	server := tokenEndpoint(t, &fetches)
+	defer server.Close()
+
+	source := &ClientCredentials{
+		TokenURL:     server.URL,
+		ClientID:     "client",
+		ClientSecret: "wrong",
+	}
+
+	_, err := source.Token(context.Background())
+
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) {
+		t.Fatalf("Error[%v] Expected A TokenError", err)
+	}
+
+	if tokenErr.StatusCode != http.StatusUnauthorized || tokenErr.Code != "invalid_client" || tokenErr.Description != "Unknown Client" {
+		t.Errorf("Status[%d] Code[%s] Description[%s]", tokenErr.StatusCode, tokenErr.Code, tokenErr.Description)
+	}
+}
+
+func TestCachedTokenSourceSharesFetch(t *testing.T) {
+	var fetches int32
+	server := tokenEndpoint(t, &fetches)
+	defer server.Close()
+
+	source := NewCachedTokenSource(&ClientCredentials{
+		TokenURL:     server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	})
+
+	var wait sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			token, err := source.Token(context.Background())
+			if err != nil {
+				t.Errorf("Token : %v", err)
+				return
+			}
+			if token.AccessToken != "token-1" {
+				t.Errorf("Token[%s] Expected[token-1]", token.AccessToken)
+			}
+		}()
+	}
+	wait.Wait()
+
+	if _, err := source.Token(context.Background()); err != nil {
+		t.Fatalf("Token : %v", err)
+	}
+
+	if fetches != 1 {
+		t.Errorf("Fetches[%d] Expected[1]", fetches)
+	}
+}
+
+func TestOAuth2RefreshesOnUnauthorized(t *testing.T) {
+	var fetches int32
+	tokens := tokenEndpoint(t, &fetches)
+	defer tokens.Close()
+
+	// The api only accepts the second token issued
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer api.Close()
+
+	client := NewTransport(nil)
+	client.Auth = NewCachedTokenSource(&ClientCredentials{
+		TokenURL:     tokens.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	})
+
+	body, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatalf("Get : %v", err)
+	}
+
+	if string(body) != "ok" || fetches != 2 {
+		t.Errorf("Body[%s] Fetches[%d] Expected[ok] Fetches[2]", body, fetches)
+	}
+}
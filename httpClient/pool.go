@@ -0,0 +1,349 @@
+package httpClient
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	// retireInterval is how often a replaced http transport closes the
+	// connections its requests released.
+	retireInterval = time.Second
+
+	// defaultRetireTimeout is how long a replaced http transport waits for
+	// its requests when neither RequestTimeout nor IdleConnTimeout is set.
+	defaultRetireTimeout = 10 * time.Minute
+)
+
+type (
+	// Timeouts holds the timeouts of the transport that can be changed
+	// while requests are running.
+	Timeouts struct {
+		Connect        time.Duration
+		ResponseHeader time.Duration
+		Request        time.Duration
+	}
+
+	// PoolStats is a snapshot of the connection pool.
+	PoolStats struct {
+		// InFlight is the number of requests whose response body is not
+		// closed yet.
+		InFlight int64
+
+		Hosts []HostPoolStats
+	}
+
+	// HostPoolStats describes the connections dialed to an address, which
+	// is the proxy when one is used.
+	HostPoolStats struct {
+		Addr       string
+		Open       int64
+		Dials      int64
+		DialErrors int64
+		Closed     int64
+	}
+
+	// poolTracker counts the connections of the transport. It outlives the
+	// http transports replaced by SetTimeouts.
+	poolTracker struct {
+		lock     sync.Mutex
+		hosts    map[string]*HostPoolStats
+		inFlight int64
+	}
+
+	// pooledTransport is an http transport counting the requests it runs
+	// so it can be retired once they are done.
+	pooledTransport struct {
+		*http.Transport
+		active int64
+	}
+
+	// trackedConn reports its close to the tracker.
+	trackedConn struct {
+		net.Conn
+		once    sync.Once
+		tracker *poolTracker
+		addr    string
+	}
+
+	// inFlightBody releases the in flight count once the body is closed.
+	inFlightBody struct {
+		io.ReadCloser
+		once      sync.Once
+		tracker   *poolTracker
+		transport *pooledTransport
+	}
+)
+
+// lazyStart builds the http transport on first use.
+func (t *Transport) lazyStart() {
+	t.transport = t.newTransport()
+}
+
+// newTransport builds an http transport from the settings.
+func (t *Transport) newTransport() *pooledTransport {
+	dialer := &net.Dialer{Timeout: t.ConnectTimeout}
+	return &pooledTransport{Transport: &http.Transport{
+		DialContext:            t.pool.dialContext(dialer),
+		Proxy:                  t.Proxy,
+		OnProxyConnectResponse: onProxyConnectResponse,
+		TLSClientConfig:        t.TLSClientConfig,
+		DisableKeepAlives:      t.DisableKeepAlives,
+		DisableCompression:     t.DisableCompression,
+		MaxIdleConns:           t.MaxIdleConns,
+		MaxIdleConnsPerHost:    t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:        t.MaxConnsPerHost,
+		IdleConnTimeout:        t.IdleConnTimeout,
+		ResponseHeaderTimeout:  t.ResponseHeaderTimeout,
+		ForceAttemptHTTP2:      t.EnableHTTP2,
+	}}
+}
+
+// current returns the http transport and the request timeout in use.
+func (t *Transport) current() (*pooledTransport, time.Duration) {
+	t.starter.Do(t.lazyStart)
+
+	t.transportLock.RLock()
+	defer t.transportLock.RUnlock()
+
+	return t.transport, t.RequestTimeout
+}
+
+// SetTimeouts changes the timeouts at runtime. The requests in flight
+// finish with the previous settings while new requests use a new pool.
+// The connections of the previous pool are closed as its requests finish
+// and the pool is released once the last response body is closed. The
+// pool stops waiting after the longest of the previous RequestTimeout and
+// IdleConnTimeout, ten minutes when both are zero, so a response body
+// never closed does not keep it forever.
+func (t *Transport) SetTimeouts(timeouts Timeouts) {
+	t.replaceTransport(func() {
+		t.ConnectTimeout = timeouts.Connect
+		t.ResponseHeaderTimeout = timeouts.ResponseHeader
+		t.RequestTimeout = timeouts.Request
+	})
+
+	tracelog.INFO("http_client", "SetTimeouts", "Connect[%v] ResponseHeader[%v] Request[%v]", timeouts.Connect, timeouts.ResponseHeader, timeouts.Request)
+}
+
+// replaceTransport applies the update to the settings and swaps in a new
+// http transport built from them. The previous one is retired.
+func (t *Transport) replaceTransport(update func()) {
+	t.starter.Do(t.lazyStart)
+
+	t.transportLock.Lock()
+	limit := t.retireTimeout()
+	update()
+
+	previous := t.transport
+	t.transport = t.newTransport()
+	t.transportLock.Unlock()
+
+	go previous.retire(limit)
+}
+
+// retireTimeout returns how long a replaced http transport waits for its
+// requests, the lock must be held.
+func (t *Transport) retireTimeout() time.Duration {
+	limit := t.RequestTimeout
+	if t.IdleConnTimeout > limit {
+		limit = t.IdleConnTimeout
+	}
+
+	if limit <= 0 {
+		return defaultRetireTimeout
+	}
+
+	return limit
+}
+
+// GetTimeouts returns the timeouts in use.
+func (t *Transport) GetTimeouts() Timeouts {
+	t.transportLock.RLock()
+	defer t.transportLock.RUnlock()
+
+	return Timeouts{
+		Connect:        t.ConnectTimeout,
+		ResponseHeader: t.ResponseHeaderTimeout,
+		Request:        t.RequestTimeout,
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the pool, for
+// instance after a DNS change. Connections in use are not interrupted.
+func (t *Transport) CloseIdleConnections() {
+	transport, _ := t.current()
+	transport.CloseIdleConnections()
+}
+
+// PoolStats returns a snapshot of the connection pool.
+func (t *Transport) PoolStats() PoolStats {
+	return t.pool.snapshot()
+}
+
+// ReportPoolStats calls report with the pool stats at every interval
+// until the context is done. The stats are logged when report is nil.
+func (t *Transport) ReportPoolStats(ctx context.Context, interval time.Duration, report func(PoolStats)) {
+	if report == nil {
+		report = logPoolStats
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				report(t.PoolStats())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// logPoolStats writes the pool stats to the log.
+func logPoolStats(stats PoolStats) {
+	tracelog.INFO("http_client", "PoolStats", "InFlight[%d]", stats.InFlight)
+	for _, host := range stats.Hosts {
+		tracelog.INFO("http_client", "PoolStats", "Addr[%s] Open[%d] Dials[%d] DialErrors[%d] Closed[%d]", host.Addr, host.Open, host.Dials, host.DialErrors, host.Closed)
+	}
+}
+
+// acquire counts a request run by the transport.
+func (pt *pooledTransport) acquire() {
+	atomic.AddInt64(&pt.active, 1)
+}
+
+// release counts a request of the transport as done.
+func (pt *pooledTransport) release() {
+	atomic.AddInt64(&pt.active, -1)
+}
+
+// retire closes the connections of a replaced transport as its requests
+// release them, until the last request is done or the limit is reached.
+// Connections are only returned to the pool once the response body is
+// closed, so the limit bounds the wait on bodies never closed.
+func (pt *pooledTransport) retire(limit time.Duration) {
+	interval := retireInterval
+	if limit < interval {
+		interval = limit
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(limit)
+	for {
+		active := atomic.LoadInt64(&pt.active)
+		pt.CloseIdleConnections()
+		if active == 0 {
+			return
+		}
+
+		if !time.Now().Before(deadline) {
+			tracelog.WARN("http_client", "retire", "Giving Up On Replaced Transport Active[%d]", active)
+			return
+		}
+
+		<-ticker.C
+	}
+}
+
+// dialContext wraps the dialer to count the connections.
+func (pt *poolTracker) dialContext(dialer *net.Dialer) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+
+		pt.lock.Lock()
+		host := pt.host(addr)
+		host.Dials++
+		if err != nil {
+			host.DialErrors++
+		} else {
+			host.Open++
+		}
+		pt.lock.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &trackedConn{Conn: conn, tracker: pt, addr: addr}, nil
+	}
+}
+
+// host returns the stats of the address, the lock must be held.
+func (pt *poolTracker) host(addr string) *HostPoolStats {
+	if pt.hosts == nil {
+		pt.hosts = map[string]*HostPoolStats{}
+	}
+
+	host := pt.hosts[addr]
+	if host == nil {
+		host = &HostPoolStats{Addr: addr}
+		pt.hosts[addr] = host
+	}
+
+	return host
+}
+
+// track counts the response as in flight until its body is closed. The
+// request is then released from the transport that ran it.
+func (pt *poolTracker) track(resp *http.Response, transport *pooledTransport) *http.Response {
+	atomic.AddInt64(&pt.inFlight, 1)
+	resp.Body = &inFlightBody{ReadCloser: resp.Body, tracker: pt, transport: transport}
+	return resp
+}
+
+// snapshot copies the stats sorted by address.
+func (pt *poolTracker) snapshot() PoolStats {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+
+	stats := PoolStats{InFlight: atomic.LoadInt64(&pt.inFlight)}
+	for _, host := range pt.hosts {
+		stats.Hosts = append(stats.Hosts, *host)
+	}
+
+	sort.Slice(stats.Hosts, func(i, j int) bool {
+		return stats.Hosts[i].Addr < stats.Hosts[j].Addr
+	})
+
+	return stats
+}
+
+// Close closes the connection and records it.
+func (tc *trackedConn) Close() error {
+	tc.once.Do(func() {
+		tc.tracker.lock.Lock()
+		host := tc.tracker.host(tc.addr)
+		host.Open--
+		host.Closed++
+		tc.tracker.lock.Unlock()
+	})
+
+	return tc.Conn.Close()
+}
+
+// Close closes the body and releases the in flight count. The body is
+// closed first so the connection is back in the pool once released.
+func (ifb *inFlightBody) Close() error {
+	err := ifb.ReadCloser.Close()
+
+	ifb.once.Do(func() {
+		atomic.AddInt64(&ifb.tracker.inFlight, -1)
+		ifb.transport.release()
+	})
+
+	return err
+}
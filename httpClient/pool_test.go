@@ -0,0 +1,100 @@
+package httpClient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetTimeoutsWithRequestsInFlight(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	client := NewTransport(nil)
+
+	stop := make(chan struct{})
+	reconfigured := make(chan struct{})
+	go func() {
+		defer close(reconfigured)
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(2 * time.Millisecond):
+			}
+
+			client.SetTimeouts(Timeouts{
+				Connect:        time.Duration(i) * time.Second,
+				ResponseHeader: time.Second,
+				Request:        5 * time.Second,
+			})
+		}
+	}()
+
+	var wait sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 20; i++ {
+				body, err := client.Get(server.URL)
+				if err != nil || string(body) != "ok" {
+					t.Errorf("Body[%s] Error[%v]", body, err)
+					return
+				}
+			}
+		}()
+	}
+
+	wait.Wait()
+	close(stop)
+	<-reconfigured
+
+	client.CloseIdleConnections()
+
+	// The replaced transports close their connections once released
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		stats := client.PoolStats()
+
+		open := int64(0)
+		for _, host := range stats.Hosts {
+			open += host.Open
+		}
+
+		if stats.InFlight == 0 && open == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("InFlight[%d] Open[%d] Expected No Connection Left", stats.InFlight, open)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRetireGivesUp(t *testing.T) {
+	transport := &pooledTransport{Transport: &http.Transport{}}
+
+	// A request whose body is never closed
+	transport.acquire()
+
+	done := make(chan struct{})
+	go func() {
+		transport.retire(50 * time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Retire Did Not Give Up")
+	}
+}
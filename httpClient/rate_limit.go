@@ -0,0 +1,223 @@
+package httpClient
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+type (
+	// RateLimit is a token bucket allowing RequestsPerSecond with bursts
+	// of up to Burst requests.
+	RateLimit struct {
+		RequestsPerSecond float64
+		Burst             int
+	}
+
+	// RateLimitPolicy controls the rate of requests sent to each host.
+	RateLimitPolicy struct {
+		// Default, if non-nil, is the limit of the hosts not listed in Hosts.
+		Default *RateLimit
+
+		// Hosts holds the limits by host name or host:port.
+		Hosts map[string]RateLimit
+
+		// FailFast, if true, rejects the requests that would have to wait
+		// with a *RateLimitError instead of blocking.
+		FailFast bool
+
+		// BackoffOn429, if non-zero, is how long the host is paused after
+		// a 429 response without a Retry-After header.
+		BackoffOn429 time.Duration
+	}
+
+	// RateLimitError is returned when a request is rejected by the rate
+	// limiter, either because FailFast is set or the wait would exceed the
+	// request deadline.
+	RateLimitError struct {
+		Host string
+		Wait time.Duration
+	}
+
+	// tokenBucket is the limiter of a single host.
+	tokenBucket struct {
+		lock        sync.Mutex
+		rate        float64
+		burst       float64
+		tokens      float64
+		last        time.Time
+		pausedUntil time.Time
+	}
+)
+
+// Error returns the error message for the rejected request.
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded for host %s, wait %v", e.Host, e.Wait)
+}
+
+// limitFor returns the rate limit configured for the host.
+func (p *RateLimitPolicy) limitFor(req *http.Request) *RateLimit {
+	if limit, ok := p.Hosts[req.URL.Host]; ok {
+		return &limit
+	}
+
+	if limit, ok := p.Hosts[req.URL.Hostname()]; ok {
+		return &limit
+	}
+
+	return p.Default
+}
+
+// bucketFor returns the token bucket for the host of the request, creating
+// it on first use. Nil is returned when the host is not limited.
+func (t *Transport) bucketFor(req *http.Request) *tokenBucket {
+	if t.RateLimit == nil {
+		return nil
+	}
+
+	limit := t.RateLimit.limitFor(req)
+	if limit == nil || limit.RequestsPerSecond <= 0 {
+		return nil
+	}
+
+	t.bucketsLock.Lock()
+	defer t.bucketsLock.Unlock()
+
+	if t.buckets == nil {
+		t.buckets = map[string]*tokenBucket{}
+	}
+
+	bucket := t.buckets[req.URL.Host]
+	if bucket == nil {
+		burst := float64(limit.Burst)
+		if burst < 1 {
+			burst = 1
+		}
+
+		bucket = &tokenBucket{rate: limit.RequestsPerSecond, burst: burst, tokens: burst, last: time.Now()}
+		t.buckets[req.URL.Host] = bucket
+	}
+
+	return bucket
+}
+
+// waitRateLimit blocks until the request can be sent to its host.
+func (t *Transport) waitRateLimit(req *http.Request) error {
+	bucket := t.bucketFor(req)
+	if bucket == nil {
+		return nil
+	}
+
+	// Never wait longer than the request would be allowed to run. The
+	// context carries the deadline of the RequestTimeout, so the wait and
+	// the request share it
+	maxWait := time.Duration(-1)
+	if deadline, ok := req.Context().Deadline(); ok {
+		maxWait = time.Until(deadline)
+		if maxWait < 0 {
+			maxWait = 0
+		}
+	}
+	if t.RateLimit.FailFast {
+		maxWait = 0
+	}
+
+	wait, ok := bucket.reserve(time.Now(), maxWait)
+	if !ok {
+		return &RateLimitError{Host: req.URL.Host, Wait: wait}
+	}
+
+	if wait <= 0 {
+		return nil
+	}
+
+	tracelog.TRACE("http_client", "waitRateLimit", "Host[%s] Wait[%v]", req.URL.Host, wait)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		bucket.cancel()
+		return contextError(req.Context(), req, req.Context().Err())
+	}
+}
+
+// slowDown pauses the host after a 429 response.
+func (t *Transport) slowDown(req *http.Request, resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+
+	bucket := t.bucketFor(req)
+	if bucket == nil {
+		return
+	}
+
+	delay, ok := retryAfter(resp)
+	if !ok {
+		delay = t.RateLimit.BackoffOn429
+	}
+
+	if delay > 0 {
+		tracelog.WARN("http_client", "slowDown", "Host[%s] Paused[%v]", req.URL.Host, delay)
+		bucket.pause(time.Now().Add(delay))
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it. When
+// the wait exceeds maxWait, a negative maxWait meaning no limit, no token is
+// taken and false is returned.
+func (tb *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	// Refill the bucket for the time elapsed
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	var wait time.Duration
+	if tb.tokens < 1 {
+		wait = time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+	}
+
+	if pause := tb.pausedUntil.Sub(now); pause > wait {
+		wait = pause
+	}
+
+	if maxWait >= 0 && wait > maxWait {
+		return wait, false
+	}
+
+	tb.tokens--
+	return wait, true
+}
+
+// cancel gives back the token of an abandoned reservation.
+func (tb *tokenBucket) cancel() {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	tb.tokens++
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+// pause stops the host from being used until the specified time.
+func (tb *tokenBucket) pause(until time.Time) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	if until.After(tb.pausedUntil) {
+		tb.pausedUntil = until
+	}
+}
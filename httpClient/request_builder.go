@@ -0,0 +1,299 @@
+package httpClient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ArdanStudios/go-common/helper"
+	"github.com/goinggo/tracelog"
+)
+
+var (
+	// JSONEncoder encodes request bodies as JSON documents.
+	JSONEncoder = BodyEncoderFunc(encodeJSON)
+
+	// XMLEncoder encodes request bodies as XML documents.
+	XMLEncoder = BodyEncoderFunc(encodeXML)
+
+	// FormEncoder encodes url.Values or map[string]string request bodies
+	// as urlencoded forms.
+	FormEncoder = BodyEncoderFunc(encodeForm)
+)
+
+type (
+	// BodyEncoder encodes a value as a request body.
+	BodyEncoder interface {
+		Encode(value interface{}) (contentType string, body []byte, err error)
+	}
+
+	// BodyEncoderFunc adapts a function to the BodyEncoder interface.
+	BodyEncoderFunc func(value interface{}) (string, []byte, error)
+
+	// RequestBuilder builds a request step by step and executes it through
+	// the transport. The first error is kept and returned by Build.
+	RequestBuilder struct {
+		transport   *Transport
+		ctx         context.Context
+		method      string
+		path        string
+		query       url.Values
+		header      http.Header
+		body        io.Reader
+		contentType string
+		err         error
+	}
+)
+
+// Encode calls the function.
+func (f BodyEncoderFunc) Encode(value interface{}) (string, []byte, error) {
+	return f(value)
+}
+
+// NewRequest starts building a request for the method. The path is joined
+// to the transport's BaseURL unless it is an absolute url.
+func (t *Transport) NewRequest(method string, path string) *RequestBuilder {
+	return &RequestBuilder{
+		transport: t,
+		ctx:       context.Background(),
+		method:    strings.ToUpper(method),
+		path:      path,
+		query:     url.Values{},
+		header:    http.Header{},
+	}
+}
+
+// Context binds the request to the context.
+func (rb *RequestBuilder) Context(ctx context.Context) *RequestBuilder {
+	rb.ctx = ctx
+	return rb
+}
+
+// Query adds a query parameter.
+func (rb *RequestBuilder) Query(key string, value string) *RequestBuilder {
+	rb.query.Add(key, value)
+	return rb
+}
+
+// QueryValues adds the query parameters.
+func (rb *RequestBuilder) QueryValues(values url.Values) *RequestBuilder {
+	for key, list := range values {
+		for _, value := range list {
+			rb.query.Add(key, value)
+		}
+	}
+	return rb
+}
+
+// QueryMap adds the query parameters of the map.
+func (rb *RequestBuilder) QueryMap(values map[string]string) *RequestBuilder {
+	return rb.QueryValues(helper.ToUrlValues(values))
+}
+
+// Header sets a request header.
+func (rb *RequestBuilder) Header(key string, value string) *RequestBuilder {
+	rb.header.Set(key, value)
+	return rb
+}
+
+// Headers sets the request headers of the map.
+func (rb *RequestBuilder) Headers(headers map[string]string) *RequestBuilder {
+	for key, value := range headers {
+		rb.header.Set(key, value)
+	}
+	return rb
+}
+
+// Body sets the request body. Bodies read from a *bytes.Reader,
+// *bytes.Buffer or *strings.Reader can be replayed by the retry policy.
+func (rb *RequestBuilder) Body(contentType string, body io.Reader) *RequestBuilder {
+	rb.contentType = contentType
+	rb.body = body
+	return rb
+}
+
+// Encode sets the request body to the value encoded with the encoder.
+func (rb *RequestBuilder) Encode(encoder BodyEncoder, value interface{}) *RequestBuilder {
+	contentType, body, err := encoder.Encode(value)
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "RequestBuilder.Encode")
+		if rb.err == nil {
+			rb.err = err
+		}
+		return rb
+	}
+
+	return rb.Body(contentType, bytes.NewReader(body))
+}
+
+// JSON sets the request body to the value encoded as JSON.
+func (rb *RequestBuilder) JSON(value interface{}) *RequestBuilder {
+	return rb.Encode(JSONEncoder, value)
+}
+
+// Form sets the request body to the urlencoded form.
+func (rb *RequestBuilder) Form(values url.Values) *RequestBuilder {
+	return rb.Encode(FormEncoder, values)
+}
+
+// Build returns the http request.
+func (rb *RequestBuilder) Build() (*http.Request, error) {
+	if rb.err != nil {
+		return nil, rb.err
+	}
+
+	reqURL, err := rb.transport.resolveURL(rb.path)
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "RequestBuilder.Build")
+		return nil, err
+	}
+
+	if len(rb.query) > 0 {
+		query := reqURL.Query()
+		for key, list := range rb.query {
+			query[key] = append(query[key], list...)
+		}
+		reqURL.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(rb.ctx, rb.method, reqURL.String(), rb.body)
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "RequestBuilder.Build")
+		return nil, err
+	}
+
+	for key, values := range rb.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+
+	if rb.contentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", rb.contentType)
+	}
+
+	return req, nil
+}
+
+// Do executes the request and reads the response.
+func (rb *RequestBuilder) Do() (*Response, error) {
+	req, err := rb.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return rb.transport.Do(req)
+}
+
+// DoJSON executes the request and decodes the response into target, if
+// not nil, the same way as Transport.DoJSON.
+func (rb *RequestBuilder) DoJSON(target interface{}) (*Response, error) {
+	if rb.header.Get("Accept") == "" {
+		rb.header.Set("Accept", "application/json")
+	}
+
+	resp, err := rb.Do()
+	if err != nil {
+		return resp, err
+	}
+
+	if err := decodeJSON(resp.Body, target); err != nil {
+		return resp, err
+	}
+
+	return resp, nil
+}
+
+// Stream executes the request and returns the response without reading
+// its body. The caller must close the body.
+func (rb *RequestBuilder) Stream() (*StreamResponse, error) {
+	req, err := rb.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return rb.transport.DoStream(req)
+}
+
+// resolveURL joins the path to the BaseURL. Absolute urls and paths used
+// without a BaseURL are returned as is.
+func (t *Transport) resolveURL(path string) (*url.URL, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.BaseURL == "" || ref.IsAbs() {
+		return ref, nil
+	}
+
+	base, err := url.Parse(t.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Join the escaped paths so escaped slashes in segments are kept
+	resolved := *base
+	resolved.RawPath = strings.TrimRight(base.EscapedPath(), "/") + "/" + strings.TrimLeft(ref.EscapedPath(), "/")
+	if resolved.Path, err = url.PathUnescape(resolved.RawPath); err != nil {
+		return nil, err
+	}
+	resolved.Fragment = ref.Fragment
+
+	if ref.RawQuery != "" {
+		query := base.Query()
+		for key, list := range ref.Query() {
+			query[key] = append(query[key], list...)
+		}
+		resolved.RawQuery = query.Encode()
+	}
+
+	return &resolved, nil
+}
+
+// resolveRequest joins a relative request url to the BaseURL.
+func (t *Transport) resolveRequest(req *http.Request) error {
+	if t.BaseURL == "" || req.URL.IsAbs() {
+		return nil
+	}
+
+	reqURL, err := t.resolveURL(req.URL.String())
+	if err != nil {
+		return err
+	}
+
+	req.URL = reqURL
+	req.Host = reqURL.Host
+	return nil
+}
+
+// encodeJSON encodes the value as a JSON document.
+func encodeJSON(value interface{}) (string, []byte, error) {
+	body, err := json.Marshal(value)
+	return "application/json", body, err
+}
+
+// encodeXML encodes the value as an XML document.
+func encodeXML(value interface{}) (string, []byte, error) {
+	body, err := xml.Marshal(value)
+	return "application/xml", body, err
+}
+
+// encodeForm encodes url.Values or map[string]string as a urlencoded form.
+func encodeForm(value interface{}) (string, []byte, error) {
+	var values url.Values
+	switch form := value.(type) {
+	case url.Values:
+		values = form
+	case map[string]string:
+		values = helper.ToUrlValues(form)
+	default:
+		return "", nil, fmt.Errorf("Unsupported Form Type %T", value)
+	}
+
+	return "application/x-www-form-urlencoded", []byte(values.Encode()), nil
+}
@@ -0,0 +1,70 @@
+package httpClient
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxErrorBodyLength is the number of body bytes kept in an HTTPError.
+	maxErrorBodyLength = 512
+)
+
+type (
+	// Response is the result of a request executed through the transport.
+	Response struct {
+		StatusCode  int
+		Status      string
+		Header      http.Header
+		ContentType string
+		Body        []byte
+
+		// CacheStatus is MISS, HIT or REVALIDATED when the transport has a
+		// cache and blank otherwise.
+		CacheStatus string
+
+		Method string
+		URL    string
+
+		Started  time.Time
+		Duration time.Duration
+	}
+
+	// HTTPError is returned when the upstream answers with a non 2xx status.
+	HTTPError struct {
+		StatusCode int
+		Method     string
+		URL        string
+		Body       string
+	}
+)
+
+// IsSuccess returns true if the response has a 2xx status code.
+func (r *Response) IsSuccess() bool {
+	return isSuccessStatus(r.StatusCode)
+}
+
+// Error returns the status, request and truncated body of the failed call.
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s %s : status[%d] : %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// newHTTPError creates an HTTPError truncating the body.
+func newHTTPError(method string, url string, statusCode int, body []byte) *HTTPError {
+	if len(body) > maxErrorBodyLength {
+		body = body[:maxErrorBodyLength]
+	}
+
+	return &HTTPError{
+		StatusCode: statusCode,
+		Method:     method,
+		URL:        url,
+		Body:       string(body),
+	}
+}
+
+// isSuccessStatus checks for a 2xx status code.
+func isSuccessStatus(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300
+}
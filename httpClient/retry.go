@@ -0,0 +1,287 @@
+package httpClient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	// IDEMPOTENCY_KEY_HEADER marks a request with a non idempotent method
+	// as safe to retry.
+	IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+)
+
+type (
+	// RetryPolicy controls how the transport replays failed requests.
+	RetryPolicy struct {
+		// MaxAttempts is the total number of attempts made for a request,
+		// including the first one. Values less than 2 disable retries.
+		MaxAttempts int
+
+		// BaseBackoff is the delay before the first retry. Each following
+		// retry doubles the previous delay.
+		BaseBackoff time.Duration
+
+		// MaxBackoff, if non-zero, caps the delay between two attempts.
+		MaxBackoff time.Duration
+
+		// Jitter is the fraction (0 to 1) of each delay that is randomized
+		// so concurrent clients do not retry in lock step.
+		Jitter float64
+
+		// RetryableStatusCodes lists the response status codes that
+		// trigger a retry. Transport errors are always retried.
+		RetryableStatusCodes []int
+
+		// IgnoreRetryAfter, if true, prevents the Retry-After header of a
+		// retryable response from overriding the computed backoff. The
+		// header is capped by MaxBackoff when it is set.
+		IgnoreRetryAfter bool
+	}
+
+	// RetryError is returned when a request failed after the retry
+	// policy was applied.
+	RetryError struct {
+		Attempts   int
+		Elapsed    time.Duration
+		StatusCode int
+		Err        error
+	}
+)
+
+var (
+	// random is the source used to jitter backoff delays.
+	random     = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomLock sync.Mutex
+)
+
+// NewRetryPolicy creates a retry policy with sensible defaults for
+// the specified number of attempts.
+func NewRetryPolicy(maxAttempts int) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:          maxAttempts,
+		BaseBackoff:          250 * time.Millisecond,
+		MaxBackoff:           10 * time.Second,
+		Jitter:               0.2,
+		RetryableStatusCodes: []int{429, 500, 502, 503, 504},
+	}
+}
+
+// Error returns the underlying error decorated with the retry information.
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v (attempts[%d] elapsed[%v])", e.Err, e.Attempts, e.Elapsed)
+}
+
+// Unwrap returns the error of the last attempt.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// isRetryableStatus checks if the status code is part of the policy.
+func (p *RetryPolicy) isRetryableStatus(statusCode int) bool {
+	for _, code := range p.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff calculates the delay to wait before the specified retry.
+func (p *RetryPolicy) backoff(retry int, resp *http.Response) time.Duration {
+	if resp != nil && !p.IgnoreRetryAfter {
+		if delay, ok := retryAfter(resp); ok {
+			if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+				delay = p.MaxBackoff
+			}
+			return delay
+		}
+	}
+
+	delay := p.BaseBackoff
+	for i := 1; i < retry; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 && delay > 0 {
+		randomLock.Lock()
+		factor := random.Float64()
+		randomLock.Unlock()
+
+		delay -= time.Duration(float64(delay) * p.Jitter * factor)
+	}
+
+	return delay
+}
+
+// retryAfter parses the Retry-After header which is either a number of
+// seconds or an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(time.Now())
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
+
+// isIdempotent checks if the method can safely be sent more than once.
+func isIdempotent(method string) bool {
+	switch method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
+
+// canReplay checks if the request can be sent again by the retry policy.
+// The method must be idempotent, or the caller must set an Idempotency-Key
+// so the upstream can discard duplicates, and the body must be replayable.
+func canReplay(req *http.Request) bool {
+	if !isIdempotent(req.Method) && req.Header.Get(IDEMPOTENCY_KEY_HEADER) == "" {
+		return false
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		return req.GetBody != nil
+	}
+
+	return true
+}
+
+// isPermanent checks if the error, or an error it wraps, is raised by the
+// transport itself and does not reflect the health of the upstream.
+func isPermanent(err error) bool {
+	var (
+		circuitErr   *CircuitOpenError
+		rateLimitErr *RateLimitError
+		tooLargeErr  *ResponseTooLargeError
+		pinErr       *PinMismatchError
+	)
+
+	return errors.As(err, &circuitErr) || errors.As(err, &rateLimitErr) || errors.As(err, &tooLargeErr) || errors.As(err, &pinErr)
+}
+
+// rewindRequest returns a copy of the request with a fresh body.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	newReq := new(http.Request)
+	*newReq = *req
+	newReq.Body = body
+	return newReq, nil
+}
+
+// drainBody discards what is left of a response body so the connection
+// can be reused, returning a short prefix of the body for error reporting.
+func drainBody(resp *http.Response) []byte {
+	defer resp.Body.Close()
+
+	contents, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+	return contents
+}
+
+// roundTripWithRetry executes the request applying the retry policy.
+func (t *Transport) roundTripWithRetry(req *http.Request) (*http.Response, error) {
+	policy := t.Retry
+	started := time.Now()
+
+	attempt := 0
+	for {
+		attempt++
+
+		attemptReq := req
+		if attempt > 1 {
+			var err error
+			if attemptReq, err = rewindRequest(req); err != nil {
+				return nil, &RetryError{Attempts: attempt - 1, Elapsed: time.Since(started), Err: err}
+			}
+		}
+
+		resp, err := t.attempt(attemptReq)
+
+		// An open circuit, a rate limit rejection, an oversized response or
+		// a pin mismatch won't change with a retry
+		if isPermanent(err) {
+			return nil, err
+		}
+
+		// The caller gave up on the request
+		if req.Context().Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if err == nil {
+				err = req.Context().Err()
+			}
+			return nil, &RetryError{Attempts: attempt, Elapsed: time.Since(started), Err: contextError(req.Context(), req, err)}
+		}
+
+		final := attempt >= policy.MaxAttempts
+		if err != nil {
+			if final {
+				return nil, &RetryError{Attempts: attempt, Elapsed: time.Since(started), Err: err}
+			}
+		} else if !policy.isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		} else if final {
+			statusCode := resp.StatusCode
+			err = newHTTPError(req.Method, req.URL.String(), statusCode, drainBody(resp))
+			return nil, &RetryError{Attempts: attempt, Elapsed: time.Since(started), StatusCode: statusCode, Err: err}
+		}
+
+		delay := policy.backoff(attempt, resp)
+		if err != nil {
+			tracelog.WARN("http_client", "roundTripWithRetry", "Attempt[%d] Url[%s] Error[%v] Retry In[%v]", attempt, req.URL, err, delay)
+		} else {
+			tracelog.WARN("http_client", "roundTripWithRetry", "Attempt[%d] Url[%s] Status[%d] Retry In[%v]", attempt, req.URL, resp.StatusCode, delay)
+			drainBody(resp)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, &RetryError{Attempts: attempt, Elapsed: time.Since(started), Err: contextError(req.Context(), req, req.Context().Err())}
+		}
+	}
+}
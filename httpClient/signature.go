@@ -0,0 +1,37 @@
+package httpClient
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ArdanStudios/go-common/crypto"
+	"github.com/goinggo/tracelog"
+)
+
+// signRequest returns a copy of the request carrying the signature of its
+// body. Each attempt is signed with a new nonce so retries are not taken
+// for replays.
+func (t *Transport) signRequest(req *http.Request) (*http.Request, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "signRequest")
+		return nil, err
+	}
+
+	signedReq := CloneRequest(req)
+	if req.GetBody == nil && len(body) > 0 {
+		// The body was consumed when it could not be rewound
+		signedReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+		signedReq.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	if err := crypto.SignRequest(signedReq, body, t.SigningKey); err != nil {
+		return nil, err
+	}
+
+	return signedReq, nil
+}
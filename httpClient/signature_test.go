@@ -0,0 +1,75 @@
+package httpClient
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ArdanStudios/go-common/crypto"
+)
+
+// signedServer verifies the signature of the requests it receives. When
+// decode is true the body is decoded first, like a web framework copying
+// a gzip request body.
+func signedServer(t *testing.T, verifier *crypto.RequestVerifier, decode bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Content-Encoding[%s] Expected[gzip]", r.Header.Get("Content-Encoding"))
+		}
+
+		reader := r.Body
+		if decode {
+			gzipReader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("Decoding Body : %v", err)
+				return
+			}
+			reader = gzipReader
+		}
+
+		body, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Errorf("Reading Body : %v", err)
+			return
+		}
+
+		if err := verifier.VerifyBody(r, body); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+}
+
+func TestSignedCompressedRequest(t *testing.T) {
+	for _, decode := range []bool{false, true} {
+		verifier, err := crypto.NewRequestVerifier("webhook-key", time.Minute)
+		if err != nil {
+			t.Fatalf("NewRequestVerifier : %v", err)
+		}
+
+		server := signedServer(t, verifier, decode)
+
+		client := NewTransport(nil)
+		client.SigningKey = "webhook-key"
+		client.Compression = NewCompressionPolicy()
+		client.Compression.MinRequestSize = 16
+
+		req, err := http.NewRequest("POST", server.URL+"/hooks?b=2&a=1", strings.NewReader(strings.Repeat(`{"event":"created"}`, 64)))
+		if err != nil {
+			t.Fatalf("NewRequest : %v", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("Decoded[%v] Do : %v", decode, err)
+		} else if resp.StatusCode != http.StatusOK {
+			t.Errorf("Decoded[%v] Status[%d] Expected[200]", decode, resp.StatusCode)
+		}
+
+		server.Close()
+	}
+}
@@ -0,0 +1,307 @@
+package httpClient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+type (
+	// StreamResponse is a response whose body is read by the caller. The
+	// transport's RequestTimeout keeps running until the body is closed, use
+	// WithRequestTimeout to allow more time for large downloads.
+	StreamResponse struct {
+		StatusCode    int
+		Status        string
+		Header        http.Header
+		ContentType   string
+		ContentLength int64
+		CacheStatus   string
+		Body          io.ReadCloser
+
+		Method  string
+		URL     string
+		Started time.Time
+	}
+
+	// ProgressFunc is called as bytes are transferred. Total is -1 when the
+	// size is not known.
+	ProgressFunc func(transferred int64, total int64)
+
+	// MultipartFile describes a file sent by UploadMultipart.
+	MultipartFile struct {
+		FieldName   string
+		FileName    string
+		ContentType string
+		Reader      io.Reader
+
+		// Size, if non-zero, is used to report the upload progress.
+		Size int64
+	}
+
+	// ResponseTooLargeError is returned when a response body exceeds the
+	// transport's MaxResponseSize.
+	ResponseTooLargeError struct {
+		Method string
+		URL    string
+		Limit  int64
+	}
+
+	// progressReader reports the bytes read through it.
+	progressReader struct {
+		io.Reader
+		progress    ProgressFunc
+		transferred int64
+		total       int64
+	}
+
+	// limitedBody aborts the read once more than limit bytes are received.
+	limitedBody struct {
+		io.ReadCloser
+		req       *http.Request
+		remaining int64
+		limit     int64
+	}
+)
+
+// Error returns the error message for the oversized response.
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("%s %s : response body exceeds %d bytes", e.Method, e.URL, e.Limit)
+}
+
+// GetStream performs a get request and returns the response without reading
+// its body. The caller must close the body.
+func (t *Transport) GetStream(ctx context.Context, url string, headers map[string]string) (*StreamResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "GetStream")
+		return nil, err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	return t.DoStream(req)
+}
+
+// DoStream executes the request and returns the response without reading
+// its body. The caller must close the body. A non 2xx status is returned
+// as an *HTTPError and the body is closed.
+func (t *Transport) DoStream(req *http.Request) (*StreamResponse, error) {
+	if err := t.resolveRequest(req); err != nil {
+		tracelog.ERROR(err, "http_client", "DoStream")
+		return nil, err
+	}
+
+	client := &http.Client{Transport: t}
+
+	t.logConfig().logRequest(req)
+
+	started := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &StreamResponse{
+		StatusCode:    resp.StatusCode,
+		Status:        resp.Status,
+		Header:        resp.Header,
+		ContentType:   resp.Header.Get("Content-Type"),
+		ContentLength: resp.ContentLength,
+		CacheStatus:   resp.Header.Get(CACHE_STATUS_HEADER),
+		Body:          resp.Body,
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		Started:       started,
+	}
+
+	t.logConfig().logResponse(&Response{
+		StatusCode: stream.StatusCode,
+		Status:     stream.Status,
+		Header:     stream.Header,
+		Method:     stream.Method,
+		URL:        stream.URL,
+		Started:    started,
+		Duration:   time.Since(started),
+	})
+
+	if !isSuccessStatus(resp.StatusCode) {
+		return nil, newHTTPError(stream.Method, stream.URL, stream.StatusCode, drainBody(resp))
+	}
+
+	return stream, nil
+}
+
+// Upload sends the reader as the request body. Size is the number of bytes
+// in the body or -1 when not known. Only bodies that net/http knows how to
+// rewind, such as a *bytes.Reader without progress, can be retried.
+func (t *Transport) Upload(ctx context.Context, method string, url string, contentType string, body io.Reader, size int64, progress ProgressFunc) (*Response, error) {
+	if progress != nil {
+		body = &progressReader{Reader: body, progress: progress, total: size}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		tracelog.ERROR(err, "http_client", "Upload")
+		return nil, err
+	}
+
+	if size >= 0 {
+		req.ContentLength = size
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return t.Do(req)
+}
+
+// UploadMultipart posts the fields and files as a multipart/form-data
+// document. The document is encoded while it is sent so files are never
+// held in memory.
+func (t *Transport) UploadMultipart(ctx context.Context, url string, fields map[string]string, files []MultipartFile, progress ProgressFunc) (*Response, error) {
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	total := int64(0)
+	for _, file := range files {
+		if file.Size <= 0 {
+			total = -1
+			break
+		}
+		total += file.Size
+	}
+
+	// The report is only called from the encoding goroutine
+	var transferred int64
+	report := func(n int64) {
+		if progress != nil {
+			transferred += n
+			progress(transferred, total)
+		}
+	}
+
+	go func() {
+		writer.CloseWithError(writeMultipart(form, fields, files, report))
+	}()
+
+	resp, err := t.Upload(ctx, "POST", url, form.FormDataContentType(), reader, -1, nil)
+
+	// Stop the encoder if the request ended before the body was consumed
+	reader.CloseWithError(io.ErrClosedPipe)
+	return resp, err
+}
+
+// writeMultipart encodes the fields and files into the form.
+func writeMultipart(form *multipart.Writer, fields map[string]string, files []MultipartFile, report func(int64)) error {
+	for key, value := range fields {
+		if err := form.WriteField(key, value); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(file.FieldName), escapeQuotes(file.FileName)))
+
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header.Set("Content-Type", contentType)
+
+		part, err := form.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := file.Reader.Read(buf)
+			if n > 0 {
+				if _, err := part.Write(buf[:n]); err != nil {
+					return err
+				}
+				report(int64(n))
+			}
+
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return form.Close()
+}
+
+// escapeQuotes escapes the characters not allowed in a quoted header value.
+func escapeQuotes(s string) string {
+	escaped := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, s[i])
+	}
+
+	return string(escaped)
+}
+
+// Read reports the progress of the bytes read.
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.Reader.Read(p)
+	if n > 0 {
+		pr.transferred += int64(n)
+		pr.progress(pr.transferred, pr.total)
+	}
+
+	return n, err
+}
+
+// limitResponse applies the MaxResponseSize guard to the response body.
+func (t *Transport) limitResponse(req *http.Request, resp *http.Response) (*http.Response, error) {
+	if t.MaxResponseSize <= 0 {
+		return resp, nil
+	}
+
+	if resp.ContentLength > t.MaxResponseSize {
+		resp.Body.Close()
+		return nil, &ResponseTooLargeError{Method: req.Method, URL: req.URL.String(), Limit: t.MaxResponseSize}
+	}
+
+	resp.Body = &limitedBody{ReadCloser: resp.Body, req: req, remaining: t.MaxResponseSize, limit: t.MaxResponseSize}
+	return resp, nil
+}
+
+// Read fails with a ResponseTooLargeError once the limit is exceeded.
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.remaining < 0 {
+		return 0, &ResponseTooLargeError{Method: lb.req.Method, URL: lb.req.URL.String(), Limit: lb.limit}
+	}
+
+	// Allow one byte past the limit to detect an oversized body
+	if int64(len(p)) > lb.remaining+1 {
+		p = p[:lb.remaining+1]
+	}
+
+	n, err := lb.ReadCloser.Read(p)
+	lb.remaining -= int64(n)
+	if lb.remaining < 0 {
+		return n + int(lb.remaining), &ResponseTooLargeError{Method: lb.req.Method, URL: lb.req.URL.String(), Limit: lb.limit}
+	}
+
+	return n, err
+}
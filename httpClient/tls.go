@@ -0,0 +1,326 @@
+package httpClient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goinggo/tracelog"
+)
+
+type (
+	// TLSOptions configures client certificates, certificate authorities and
+	// pinning for the transport. Files take precedence over PEM bytes.
+	TLSOptions struct {
+		// ClientCertFile and ClientKeyFile hold the PEM encoded client
+		// certificate and key used for mutual TLS.
+		ClientCertFile string
+		ClientKeyFile  string
+
+		// ClientCertPEM and ClientKeyPEM hold the client certificate and key
+		// when they are not read from files.
+		ClientCertPEM []byte
+		ClientKeyPEM  []byte
+
+		// CAFiles and CAPEM hold the certificate authorities trusted to sign
+		// the server certificates. The system pool is used when both are empty.
+		CAFiles []string
+		CAPEM   []byte
+
+		// PinnedCertificates holds by host name the base64 encoded SHA256 of
+		// the certificates accepted for the host. Only the certificates of
+		// the verified chain are matched. The pins of an IP address apply to
+		// every address the server certificate is verified for.
+		PinnedCertificates map[string][]string
+
+		// PinnedKeys holds by host name the base64 encoded SHA256 of the
+		// subject public key info accepted for the host.
+		PinnedKeys map[string][]string
+
+		// ReloadInterval, if non-zero, is how often the files are checked
+		// for changes. Changed files are reloaded without recreating the
+		// transport, new connections use the reloaded certificates.
+		ReloadInterval time.Duration
+	}
+
+	// PinMismatchError is returned when the server does not present a
+	// pinned certificate or key.
+	PinMismatchError struct {
+		Host         string
+		Certificates []string
+		Keys         []string
+	}
+
+	// tlsManager holds the certificates loaded from the options.
+	tlsManager struct {
+		options   *TLSOptions
+		lock      sync.RWMutex
+		cert      *tls.Certificate
+		pool      *x509.CertPool
+		modTimes  map[string]time.Time
+		lastCheck time.Time
+	}
+)
+
+// Error returns the error message for the pin mismatch.
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("certificate pin mismatch for host %s", e.Host)
+}
+
+// ConfigureTLS loads the certificates of the options and installs them in
+// TLSClientConfig. It must be called before the first request.
+func (t *Transport) ConfigureTLS(options *TLSOptions) error {
+	manager := &tlsManager{options: options, modTimes: map[string]time.Time{}}
+	if err := manager.load(); err != nil {
+		tracelog.ERROR(err, "http_client", "ConfigureTLS")
+		return err
+	}
+
+	config := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		config = t.TLSClientConfig.Clone()
+	}
+
+	if manager.cert != nil {
+		config.GetClientCertificate = manager.clientCertificate
+	}
+
+	// The server chain and host name are verified by the tls package, the
+	// pins are checked against the verified chains
+	if manager.pool != nil {
+		config.RootCAs = manager.pool
+	}
+
+	config.VerifyConnection = manager.verifyConnection
+	t.TLSClientConfig = config
+	t.certificates = manager
+	return nil
+}
+
+// reloadTLS installs the reloaded authorities. The connections already
+// open keep the previous ones.
+func (t *Transport) reloadTLS(pool *x509.CertPool) {
+	t.replaceTransport(func() {
+		config := t.TLSClientConfig.Clone()
+		config.RootCAs = pool
+		t.TLSClientConfig = config
+	})
+}
+
+// load reads the certificates from the files or the PEM bytes.
+func (tm *tlsManager) load() error {
+	options := tm.options
+
+	var cert *tls.Certificate
+	switch {
+	case options.ClientCertFile != "" || options.ClientKeyFile != "":
+		pair, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+
+	case len(options.ClientCertPEM) > 0 || len(options.ClientKeyPEM) > 0:
+		pair, err := tls.X509KeyPair(options.ClientCertPEM, options.ClientKeyPEM)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if len(options.CAFiles) > 0 || len(options.CAPEM) > 0 {
+		pool = x509.NewCertPool()
+		for _, file := range options.CAFiles {
+			contents, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if !pool.AppendCertsFromPEM(contents) {
+				return fmt.Errorf("No Certificates Found In CA File %s", file)
+			}
+		}
+
+		if len(options.CAPEM) > 0 && !pool.AppendCertsFromPEM(options.CAPEM) {
+			return errors.New("No Certificates Found In CA PEM")
+		}
+	}
+
+	modTimes := map[string]time.Time{}
+	for _, file := range tm.files() {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+
+	tm.lock.Lock()
+	tm.cert = cert
+	tm.pool = pool
+	tm.modTimes = modTimes
+	tm.lastCheck = time.Now()
+	tm.lock.Unlock()
+
+	return nil
+}
+
+// files returns the files the certificates are read from.
+func (tm *tlsManager) files() []string {
+	var files []string
+	if tm.options.ClientCertFile != "" {
+		files = append(files, tm.options.ClientCertFile, tm.options.ClientKeyFile)
+	}
+
+	return append(files, tm.options.CAFiles...)
+}
+
+// reloadIfChanged reloads the certificates when a file changed on disk and
+// hands the authorities to the transport. A failed reload keeps the
+// certificates in use.
+func (tm *tlsManager) reloadIfChanged(t *Transport) {
+	if tm.options.ReloadInterval <= 0 {
+		return
+	}
+
+	tm.lock.Lock()
+	if time.Since(tm.lastCheck) < tm.options.ReloadInterval {
+		tm.lock.Unlock()
+		return
+	}
+	tm.lastCheck = time.Now()
+
+	changed := false
+	for _, file := range tm.files() {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(tm.modTimes[file]) {
+			changed = true
+			break
+		}
+	}
+	tm.lock.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if err := tm.load(); err != nil {
+		tracelog.ERROR(err, "http_client", "reloadIfChanged")
+		return
+	}
+
+	tm.lock.RLock()
+	pool := tm.pool
+	tm.lock.RUnlock()
+
+	if pool != nil {
+		t.reloadTLS(pool)
+	}
+
+	tracelog.INFO("http_client", "reloadIfChanged", "TLS Certificates Reloaded")
+}
+
+// clientCertificate returns the current client certificate.
+func (tm *tlsManager) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+
+	return tm.cert, nil
+}
+
+// verifyConnection checks the pins of the host once the tls package has
+// verified the server chain.
+func (tm *tlsManager) verifyConnection(cs tls.ConnectionState) error {
+	// The server name is blank when the host is an IP address. The leaf of
+	// a verified chain is then valid for the address dialed
+	hosts := []string{cs.ServerName}
+	if cs.ServerName == "" {
+		hosts = nil
+		if len(cs.VerifiedChains) > 0 {
+			for _, ip := range cs.VerifiedChains[0][0].IPAddresses {
+				hosts = append(hosts, ip.String())
+			}
+		} else if tm.pinsAddresses() {
+			return &PinMismatchError{}
+		}
+	}
+
+	for _, host := range hosts {
+		if err := tm.checkPins(host, cs.VerifiedChains); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPins checks that a certificate of the verified chains matches a pin
+// of the host. The certificates presented but not verified are ignored.
+func (tm *tlsManager) checkPins(host string, chains [][]*x509.Certificate) error {
+	certPins := tm.options.PinnedCertificates[host]
+	keyPins := tm.options.PinnedKeys[host]
+	if len(certPins) == 0 && len(keyPins) == 0 {
+		return nil
+	}
+
+	pinErr := &PinMismatchError{Host: host}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			certHash := sha256.Sum256(cert.Raw)
+			keyHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+			certPin := base64.StdEncoding.EncodeToString(certHash[:])
+			keyPin := base64.StdEncoding.EncodeToString(keyHash[:])
+
+			if containsPin(certPins, certPin) || containsPin(keyPins, keyPin) {
+				return nil
+			}
+
+			pinErr.Certificates = append(pinErr.Certificates, certPin)
+			pinErr.Keys = append(pinErr.Keys, keyPin)
+		}
+	}
+
+	tracelog.ERRORf(pinErr, "http_client", "checkPins", "Host[%s] Keys%v", host, pinErr.Keys)
+	return pinErr
+}
+
+// pinsAddresses checks if an IP address is pinned.
+func (tm *tlsManager) pinsAddresses() bool {
+	for _, pins := range []map[string][]string{tm.options.PinnedCertificates, tm.options.PinnedKeys} {
+		for host := range pins {
+			if net.ParseIP(host) != nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// containsPin checks if the pin is in the list.
+func containsPin(pins []string, pin string) bool {
+	for _, item := range pins {
+		if item == pin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pinMismatch returns the pin error found in the chain of err, if any.
+func pinMismatch(err error) (*PinMismatchError, bool) {
+	var pinErr *PinMismatchError
+	if errors.As(err, &pinErr) {
+		return pinErr, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,124 @@
+package httpClient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	TRACEPARENT_HEADER = "traceparent"
+)
+
+type (
+	// TraceContext identifies the span an outbound request belongs to,
+	// following the W3C Trace Context specification.
+	TraceContext struct {
+		TraceID string
+		SpanID  string
+		Flags   string
+	}
+
+	// traceContextKey is the context key holding the trace context.
+	traceContextKey struct{}
+)
+
+// WithTraceContext returns a context carrying the trace context so the
+// outbound requests are linked to it by the TraceParent interceptor.
+func WithTraceContext(ctx context.Context, traceContext TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, traceContext)
+}
+
+// TraceContextFromContext returns the trace context stored in the context.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	traceContext, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return traceContext, ok
+}
+
+// ParseTraceContext parses a traceparent header, typically the one of an
+// inbound request. Invalid headers, such as all zero ids or the forbidden
+// version ff, are rejected as the specification requires.
+func ParseTraceContext(traceparent string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || !isHex(parts[0], 2) || parts[0] == "ff" {
+		return TraceContext{}, false
+	}
+
+	// Version 00 has exactly four fields, later versions may add more
+	if parts[0] == "00" && len(parts) != 4 {
+		return TraceContext{}, false
+	}
+
+	if !isHexID(parts[1], 32) || !isHexID(parts[2], 16) || !isHex(parts[3], 2) {
+		return TraceContext{}, false
+	}
+
+	return TraceContext{TraceID: parts[1], SpanID: parts[2], Flags: parts[3]}, true
+}
+
+// String renders the trace context as a traceparent header value.
+func (tc TraceContext) String() string {
+	flags := tc.Flags
+	if flags == "" {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", tc.TraceID, tc.SpanID, flags)
+}
+
+// TraceParent sets the traceparent header of every request. The trace id
+// comes from the request context or is generated, and every request gets
+// its own span id. A request which already has the header is left alone.
+func TraceParent() Interceptor {
+	return func(req *http.Request, next RoundTripFunc) (*http.Response, error) {
+		if req.Header.Get(TRACEPARENT_HEADER) != "" {
+			return next(req)
+		}
+
+		traceContext, ok := TraceContextFromContext(req.Context())
+		if !ok {
+			traceContext = TraceContext{TraceID: randomHex(16), Flags: "01"}
+		}
+		traceContext.SpanID = randomHex(8)
+
+		req = CloneRequest(req)
+		req.Header.Set(TRACEPARENT_HEADER, traceContext.String())
+		return next(req)
+	}
+}
+
+// parseTraceparent returns the trace and span ids of the header.
+func parseTraceparent(traceparent string) (string, string, bool) {
+	traceContext, ok := ParseTraceContext(traceparent)
+	return traceContext.TraceID, traceContext.SpanID, ok
+}
+
+// isHexID checks for a lower case hex id of the length that is not all zeros.
+func isHexID(id string, length int) bool {
+	return isHex(id, length) && strings.Trim(id, "0") != ""
+}
+
+// isHex checks for a lower case hex value of the length.
+func isHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+
+	for _, c := range value {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// randomHex returns size random bytes hex encoded.
+func randomHex(size int) string {
+	buf := make([]byte, size)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
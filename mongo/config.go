@@ -0,0 +1,343 @@
+package mongo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+const (
+	STRONG_MODE    = "strong"
+	MONOTONIC_MODE = "monotonic"
+	EVENTUAL_MODE  = "eventual"
+
+	// defaultDialTimeout is used when a session does not set DialTimeout.
+	defaultDialTimeout = 60 * time.Second
+)
+
+type (
+	// Duration is a time.Duration read from configuration files as a
+	// string such as "10s".
+	Duration time.Duration
+
+	// SessionConfig configures a named session. Several sessions may use
+	// the same cluster with different modes.
+	SessionConfig struct {
+		Name     string   `json:"name"`
+		Hosts    []string `json:"hosts"`
+		Database string   `json:"database"`
+		UserName string   `json:"username"`
+		Password string   `json:"password"`
+
+		// AuthSource is the database holding the credentials, the session
+		// database when blank.
+		AuthSource string `json:"auth_source"`
+
+		// Mechanism is the authentication mechanism, MONGODB-CR when blank.
+		Mechanism string `json:"mechanism"`
+
+		// ReplicaSet, if set, is checked against the set name reported by
+		// the cluster so a session never connects to the wrong set.
+		ReplicaSet string `json:"replica_set"`
+
+		// Mode is the consistency mode: strong, monotonic or eventual.
+		Mode string `json:"mode"`
+
+		// ReadTags, if set, restricts reads from secondaries to the
+		// servers matching one of the tag sets, in order of preference.
+		ReadTags []map[string]string `json:"read_tags"`
+
+		DialTimeout   Duration `json:"dial_timeout"`
+		SocketTimeout Duration `json:"socket_timeout"`
+		SyncTimeout   Duration `json:"sync_timeout"`
+
+		// PoolLimit, if non-zero, caps the connections opened to each
+		// server. Operations needing another connection fail instead.
+		PoolLimit int `json:"pool_limit"`
+	}
+
+	// Config holds the sessions created at Startup and how the registered
+	// migrations are run once they are.
+	Config struct {
+		Sessions   []SessionConfig  `json:"sessions"`
+		Migrations MigrationOptions `json:"migrations"`
+
+		// SkipMigrations leaves the pending migrations for a later Migrate.
+		SkipMigrations bool `json:"skip_migrations"`
+	}
+
+	// poolLimiter counts the connections opened to each server.
+	poolLimiter struct {
+		lock    sync.Mutex
+		limit   int
+		timeout time.Duration
+		open    map[string]int
+	}
+
+	// limitedConn releases its slot when closed.
+	limitedConn struct {
+		net.Conn
+		once    sync.Once
+		limiter *poolLimiter
+		addr    string
+	}
+)
+
+// ConfigFromEnvironment builds the default configuration from the MGO_HOSTS,
+// MGO_DATABASE, MGO_USERNAME and MGO_PASSWORD variables with a strong master
+// session and a monotonic session.
+func ConfigFromEnvironment() (*Config, error) {
+	environment := mongoConfiguration{}
+	if err := envconfig.Process("mgo", &environment); err != nil {
+		return nil, err
+	}
+
+	hosts := strings.Split(environment.Hosts, ",")
+
+	master := SessionConfig{
+		Name:     MASTER_SESSION,
+		Hosts:    hosts,
+		Database: environment.Database,
+		UserName: environment.UserName,
+		Password: environment.Password,
+		Mode:     STRONG_MODE,
+	}
+
+	monotonic := master
+	monotonic.Name = MONOTONIC_SESSION
+	monotonic.Mode = MONOTONIC_MODE
+
+	return &Config{Sessions: []SessionConfig{master, monotonic}}, nil
+}
+
+// LoadConfig reads the configuration from a JSON file.
+func LoadConfig(path string) (*Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(contents, config); err != nil {
+		return nil, err
+	}
+
+	return config, config.Validate()
+}
+
+// Validate checks the sessions are named, unique and use a known mode.
+func (config *Config) Validate() error {
+	if len(config.Sessions) == 0 {
+		return errors.New("No Sessions Configured")
+	}
+
+	names := map[string]bool{}
+	for _, session := range config.Sessions {
+		if session.Name == "" {
+			return errors.New("Session Name Is Blank")
+		}
+
+		if names[session.Name] {
+			return fmt.Errorf("Duplicate Session %s", session.Name)
+		}
+		names[session.Name] = true
+
+		if len(session.Hosts) == 0 {
+			return fmt.Errorf("Session %s Has No Hosts", session.Name)
+		}
+
+		if err := session.validateMode(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dialInfo builds the mgo dial information of the session.
+func (sessionConfig *SessionConfig) dialInfo() *mgo.DialInfo {
+	timeout := time.Duration(sessionConfig.DialTimeout)
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+
+	dialInfo := &mgo.DialInfo{
+		Addrs:     sessionConfig.Hosts,
+		Timeout:   timeout,
+		Database:  sessionConfig.Database,
+		Username:  sessionConfig.UserName,
+		Password:  sessionConfig.Password,
+		Source:    sessionConfig.AuthSource,
+		Mechanism: sessionConfig.Mechanism,
+	}
+
+	if sessionConfig.PoolLimit > 0 {
+		limiter := &poolLimiter{limit: sessionConfig.PoolLimit, timeout: timeout, open: map[string]int{}}
+		dialInfo.DialServer = limiter.dial
+	}
+
+	return dialInfo
+}
+
+// validateMode checks the consistency mode, blank meaning strong.
+func (sessionConfig *SessionConfig) validateMode() error {
+	switch strings.ToLower(sessionConfig.Mode) {
+	case "", STRONG_MODE, MONOTONIC_MODE, EVENTUAL_MODE:
+		return nil
+	}
+
+	return fmt.Errorf("Session %s Has Unknown Mode %s", sessionConfig.Name, sessionConfig.Mode)
+}
+
+// configure applies the mode, timeouts and read preferences to the session.
+func (sessionConfig *SessionConfig) configure(session *mgo.Session) error {
+	if err := sessionConfig.validateMode(); err != nil {
+		return err
+	}
+
+	// http://godoc.org/labix.org/v2/mgo#Session.SetMode
+	switch strings.ToLower(sessionConfig.Mode) {
+	case MONOTONIC_MODE:
+		// Reads may not be entirely up-to-date, but they will always see the
+		// history of changes moving forward, the data read will be consistent
+		// across sequential queries in the same session, and modifications made
+		// within the session will be observed in following queries (read-your-writes).
+		session.SetMode(mgo.Monotonic, true)
+
+	case EVENTUAL_MODE:
+		// Reads will be made to any secondary in the cluster, if available,
+		// and may be out of order.
+		session.SetMode(mgo.Eventual, true)
+
+	default:
+		// Reads and writes will always be made to the master server using a
+		// unique connection so that reads and writes are fully consistent,
+		// ordered, and observing the most up-to-date data.
+		session.SetMode(mgo.Strong, true)
+	}
+
+	if sessionConfig.SocketTimeout > 0 {
+		session.SetSocketTimeout(time.Duration(sessionConfig.SocketTimeout))
+	}
+
+	if sessionConfig.SyncTimeout > 0 {
+		session.SetSyncTimeout(time.Duration(sessionConfig.SyncTimeout))
+	}
+
+	if len(sessionConfig.ReadTags) > 0 {
+		tags := make([]bson.D, len(sessionConfig.ReadTags))
+		for index, tagSet := range sessionConfig.ReadTags {
+			tags[index] = toTagSet(tagSet)
+		}
+		session.SelectServers(tags...)
+	}
+
+	if sessionConfig.ReplicaSet != "" {
+		result := struct {
+			SetName string `bson:"setName"`
+		}{}
+
+		if err := session.Run("isMaster", &result); err != nil {
+			return err
+		}
+
+		if result.SetName != sessionConfig.ReplicaSet {
+			return fmt.Errorf("Session %s Expected Replica Set %s But Found [%s]", sessionConfig.Name, sessionConfig.ReplicaSet, result.SetName)
+		}
+	}
+
+	return nil
+}
+
+// toTagSet converts a tag map to a bson.D sorted by key.
+func toTagSet(tagSet map[string]string) bson.D {
+	keys := make([]string, 0, len(tagSet))
+	for key := range tagSet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	document := make(bson.D, len(keys))
+	for index, key := range keys {
+		document[index] = bson.DocElem{Name: key, Value: tagSet[key]}
+	}
+
+	return document
+}
+
+// UnmarshalJSON reads a duration string such as "10s" or a number of
+// nanoseconds.
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case float64:
+		*duration = Duration(v)
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*duration = Duration(parsed)
+	default:
+		return fmt.Errorf("Invalid Duration %s", string(data))
+	}
+
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (duration Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(duration).String())
+}
+
+// dial opens a connection to the server unless its limit is reached.
+func (limiter *poolLimiter) dial(addr *mgo.ServerAddr) (net.Conn, error) {
+	key := addr.String()
+
+	limiter.lock.Lock()
+	if limiter.open[key] >= limiter.limit {
+		limiter.lock.Unlock()
+		return nil, fmt.Errorf("Pool Limit Of %d Reached For %s", limiter.limit, key)
+	}
+	limiter.open[key]++
+	limiter.lock.Unlock()
+
+	conn, err := net.DialTimeout("tcp", addr.TCPAddr().String(), limiter.timeout)
+	if err != nil {
+		limiter.release(key)
+		return nil, err
+	}
+
+	return &limitedConn{Conn: conn, limiter: limiter, addr: key}, nil
+}
+
+// release frees a connection slot of the server.
+func (limiter *poolLimiter) release(key string) {
+	limiter.lock.Lock()
+	limiter.open[key]--
+	limiter.lock.Unlock()
+}
+
+// Close closes the connection and frees its slot.
+func (conn *limitedConn) Close() error {
+	conn.once.Do(func() {
+		conn.limiter.release(conn.addr)
+	})
+
+	return conn.Conn.Close()
+}
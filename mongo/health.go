@@ -0,0 +1,302 @@
+package mongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ArdanStudios/go-common/helper"
+	"github.com/goinggo/tracelog"
+)
+
+const (
+	HEALTH_UNKNOWN HealthState = iota
+	HEALTH_UP
+	HEALTH_DOWN
+)
+
+var (
+	healthLock      sync.RWMutex // Guards the health of the sessions and the callbacks
+	health          = map[string]*SessionHealth{}
+	healthCallbacks []HealthCallback
+
+	monitorLock sync.Mutex    // Serializes starting and stopping the monitor
+	monitorStop chan struct{} // Closed to stop the monitor
+	monitorDone chan struct{} // Closed when the monitor has stopped
+)
+
+type (
+	// HealthState is the health of a session as seen by the monitor.
+	HealthState int
+
+	// HealthCallback is called when the health of a session changes. err
+	// is the ping error when the session is down.
+	HealthCallback func(sessionName string, previous HealthState, current HealthState, err error)
+
+	// SessionHealth is the result of the last checks of a session.
+	SessionHealth struct {
+		Name      string      `json:"name"`
+		State     HealthState `json:"state"`
+		Latency   Duration    `json:"latency"`
+		LastError string      `json:"last_error,omitempty"`
+		LastCheck time.Time   `json:"last_check"`
+
+		// Failures is the number of consecutive failed checks.
+		Failures int `json:"failures"`
+	}
+
+	// HealthReport describes the manager and its sessions. It can be served
+	// as JSON from a health endpoint.
+	HealthReport struct {
+		State    string          `json:"state"`
+		Ready    bool            `json:"ready"`
+		Healthy  bool            `json:"healthy"`
+		Sessions []SessionHealth `json:"sessions"`
+	}
+)
+
+// String returns the name of the health state.
+func (s HealthState) String() string {
+	switch s {
+	case HEALTH_UNKNOWN:
+		return "unknown"
+	case HEALTH_UP:
+		return "up"
+	case HEALTH_DOWN:
+		return "down"
+	}
+
+	return fmt.Sprintf("HealthState(%d)", int(s))
+}
+
+// MarshalJSON writes the health state as its name.
+func (s HealthState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// OnHealthChange registers a callback fired by the monitor each time a
+// session changes health state. Callbacks must not block.
+func OnHealthChange(callback HealthCallback) {
+	healthLock.Lock()
+	healthCallbacks = append(healthCallbacks, callback)
+	healthLock.Unlock()
+}
+
+// StartHealthMonitor pings every session on the interval, giving each ping
+// up to timeout. Sessions failing a ping are refreshed so the next copies
+// reconnect. Calling it while the monitor runs does nothing.
+func StartHealthMonitor(sessionId string, interval time.Duration, timeout time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("Invalid Health Check Interval %v", interval)
+	}
+
+	if !Ready() {
+		return ErrNotRunning
+	}
+
+	monitorLock.Lock()
+	defer monitorLock.Unlock()
+
+	if monitorStop != nil {
+		return nil
+	}
+
+	tracelog.INFO(sessionId, "StartHealthMonitor", "Interval[%v] Timeout[%v]", interval, timeout)
+
+	monitorStop = make(chan struct{})
+	monitorDone = make(chan struct{})
+
+	go monitor(sessionId, interval, timeout, monitorStop, monitorDone)
+	return nil
+}
+
+// StopHealthMonitor stops the monitor and waits for the checks in progress.
+func StopHealthMonitor() {
+	monitorLock.Lock()
+	defer monitorLock.Unlock()
+
+	if monitorStop == nil {
+		return
+	}
+
+	close(monitorStop)
+	<-monitorDone
+
+	monitorStop = nil
+	monitorDone = nil
+}
+
+// CheckHealth pings every session once and records the results.
+func CheckHealth(sessionId string, timeout time.Duration) {
+	manager := currentManager()
+	if manager == nil {
+		return
+	}
+
+	sessions := manager.snapshot()
+	forgetHealth(sessions)
+
+	var wait sync.WaitGroup
+	for name, session := range sessions {
+		wait.Add(1)
+		go func(name string, session *mongoSession) {
+			defer wait.Done()
+
+			latency, err := pingSession(sessionId, session, timeout)
+			if err != nil {
+				tracelog.ERROR(err, sessionId, "CheckHealth")
+				refreshSession(sessionId, session)
+			}
+
+			recordHealth(name, latency, err)
+		}(name, session)
+	}
+
+	wait.Wait()
+}
+
+// Health returns the state of the manager and the last checks of its
+// sessions. Sessions not checked yet are reported as unknown and do not
+// make the report unhealthy.
+func Health() HealthReport {
+	state := CurrentState()
+
+	report := HealthReport{
+		State:    state.String(),
+		Ready:    state == STATE_RUNNING,
+		Sessions: []SessionHealth{},
+	}
+
+	if manager := currentManager(); manager != nil {
+		healthLock.RLock()
+		for name := range manager.snapshot() {
+			sessionHealth := SessionHealth{Name: name}
+			if checked, ok := health[name]; ok {
+				sessionHealth = *checked
+			}
+			report.Sessions = append(report.Sessions, sessionHealth)
+		}
+		healthLock.RUnlock()
+	}
+
+	sort.Sort(byName(report.Sessions))
+
+	report.Healthy = report.Ready
+	for _, sessionHealth := range report.Sessions {
+		if sessionHealth.State == HEALTH_DOWN {
+			report.Healthy = false
+		}
+	}
+
+	return report
+}
+
+// monitor runs the checks until stop is closed.
+func monitor(sessionId string, interval time.Duration, timeout time.Duration, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	defer helper.CatchPanic(nil, sessionId, "monitor")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		CheckHealth(sessionId, timeout)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pingSession pings the server using a copy of the session.
+func pingSession(sessionId string, session *mongoSession, timeout time.Duration) (latency time.Duration, err error) {
+	defer helper.CatchPanic(&err, sessionId, "pingSession")
+
+	mongoSession := session.mongoSession.Copy()
+	defer mongoSession.Close()
+
+	if timeout > 0 {
+		mongoSession.SetSyncTimeout(timeout)
+		mongoSession.SetSocketTimeout(timeout)
+	}
+
+	start := time.Now()
+	err = mongoSession.Ping()
+	return time.Since(start), err
+}
+
+// refreshSession drops the sockets of the session so later copies dial the
+// cluster again.
+func refreshSession(sessionId string, session *mongoSession) {
+	defer helper.CatchPanic(nil, sessionId, "refreshSession")
+
+	session.mongoSession.Refresh()
+}
+
+// recordHealth stores the result of a check and fires the callbacks when
+// the state of the session changed.
+func recordHealth(name string, latency time.Duration, err error) {
+	healthLock.Lock()
+
+	sessionHealth, ok := health[name]
+	if !ok {
+		sessionHealth = &SessionHealth{Name: name}
+		health[name] = sessionHealth
+	}
+
+	previous := sessionHealth.State
+	sessionHealth.LastCheck = time.Now()
+	sessionHealth.Latency = Duration(latency)
+
+	if err != nil {
+		sessionHealth.State = HEALTH_DOWN
+		sessionHealth.LastError = err.Error()
+		sessionHealth.Failures++
+	} else {
+		sessionHealth.State = HEALTH_UP
+		sessionHealth.LastError = ""
+		sessionHealth.Failures = 0
+	}
+
+	current := sessionHealth.State
+	callbacks := healthCallbacks
+
+	healthLock.Unlock()
+
+	if previous == current {
+		return
+	}
+
+	for _, callback := range callbacks {
+		callback(name, previous, current, err)
+	}
+}
+
+// forgetHealth drops the results of the sessions no longer managed.
+func forgetHealth(sessions map[string]*mongoSession) {
+	healthLock.Lock()
+	for name := range health {
+		if _, ok := sessions[name]; !ok {
+			delete(health, name)
+		}
+	}
+	healthLock.Unlock()
+}
+
+// resetHealth drops the results of all the sessions.
+func resetHealth() {
+	healthLock.Lock()
+	health = map[string]*SessionHealth{}
+	healthLock.Unlock()
+}
+
+// byName sorts the health of the sessions by name.
+type byName []SessionHealth
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
@@ -0,0 +1,163 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	STATE_STOPPED State = iota
+	STATE_STARTING
+	STATE_RUNNING
+	STATE_STOPPING
+)
+
+var (
+	// ErrNotRunning is returned when a session is requested while the
+	// manager is not running.
+	ErrNotRunning = errors.New("Mongo Manager Is Not Running")
+
+	lifecycle   sync.Mutex   // Serializes Startup and Shutdown
+	managerLock sync.RWMutex // Guards the singleton and its state
+	state       State
+)
+
+type (
+	// State is the lifecycle state of the manager.
+	State int
+
+	// StartupError holds the errors of the sessions that failed to start.
+	StartupError struct {
+		Errors map[string]error
+	}
+)
+
+// String returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case STATE_STOPPED:
+		return "stopped"
+	case STATE_STARTING:
+		return "starting"
+	case STATE_RUNNING:
+		return "running"
+	case STATE_STOPPING:
+		return "stopping"
+	}
+
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Error returns the errors of all the failed sessions.
+func (e *StartupError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, len(names))
+	for index, name := range names {
+		messages[index] = fmt.Sprintf("Session[%s] : %v", name, e.Errors[name])
+	}
+
+	return "Mongo Startup Failed : " + strings.Join(messages, " : ")
+}
+
+// CurrentState returns the lifecycle state of the manager.
+func CurrentState() State {
+	managerLock.RLock()
+	defer managerLock.RUnlock()
+
+	return state
+}
+
+// Ready checks if the manager is running and its sessions can be used.
+func Ready() bool {
+	return CurrentState() == STATE_RUNNING
+}
+
+// currentManager returns the manager while it is starting or running.
+func currentManager() *mongoManager {
+	managerLock.RLock()
+	defer managerLock.RUnlock()
+
+	if state == STATE_STOPPING {
+		return nil
+	}
+
+	return singleton
+}
+
+// setManager replaces the singleton and its state.
+func setManager(manager *mongoManager, newState State) {
+	managerLock.Lock()
+	singleton = manager
+	state = newState
+	managerLock.Unlock()
+}
+
+// setState changes the state of the manager.
+func setState(newState State) {
+	managerLock.Lock()
+	state = newState
+	managerLock.Unlock()
+}
+
+// findSession returns the named session of the running manager.
+func findSession(useSession string) (*mongoSession, error) {
+	manager := currentManager()
+	if manager == nil {
+		return nil, ErrNotRunning
+	}
+
+	manager.lock.RLock()
+	session := manager.sessions[useSession]
+	manager.lock.RUnlock()
+
+	if session == nil {
+		return nil, fmt.Errorf("Unable To Locate Session %s", useSession)
+	}
+
+	return session, nil
+}
+
+// addSession registers the session with the manager, closing the session
+// it replaces.
+func (manager *mongoManager) addSession(sessionName string, session *mongoSession) {
+	manager.lock.Lock()
+	previous := manager.sessions[sessionName]
+	manager.sessions[sessionName] = session
+	manager.lock.Unlock()
+
+	if previous != nil {
+		previous.mongoSession.Close()
+	}
+}
+
+// snapshot returns a copy of the sessions of the manager.
+func (manager *mongoManager) snapshot() map[string]*mongoSession {
+	manager.lock.RLock()
+	defer manager.lock.RUnlock()
+
+	sessions := make(map[string]*mongoSession, len(manager.sessions))
+	for name, session := range manager.sessions {
+		sessions[name] = session
+	}
+
+	return sessions
+}
+
+// closeSessions closes all the sessions of the manager.
+func (manager *mongoManager) closeSessions(sessionId string) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	for name, session := range manager.sessions {
+		CloseSession(sessionId, session.mongoSession)
+		delete(manager.sessions, name)
+	}
+}
@@ -0,0 +1,547 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ArdanStudios/go-common/helper"
+	"github.com/ArdanStudios/go-common/uuid"
+	"github.com/goinggo/tracelog"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+const (
+	MIGRATIONS_COLLECTION      = "migrations"
+	MIGRATION_LOCK_COLLECTION  = "migrations_lock"
+	MIGRATION_LOCK_ID          = "migrations"
+	defaultMigrationLockTTL    = 5 * time.Minute
+	defaultMigrationLockWait   = time.Minute
+	migrationLockRetryInterval = time.Second
+)
+
+var (
+	// ErrMigrationLocked is returned when another instance holds the
+	// migration lock for longer than the wait.
+	ErrMigrationLocked = errors.New("Migrations Are Locked By Another Instance")
+
+	// ErrMigrationLockLost is returned when the lock could not be renewed
+	// before it expired. No further migration is run.
+	ErrMigrationLockLost = errors.New("Migration Lock Was Lost")
+
+	migrationLock sync.RWMutex // Guards the registered migrations
+	migrations    = map[int]Migration{}
+)
+
+type (
+	// MigrationFunc changes the schema or the data of the database.
+	MigrationFunc func(database *mgo.Database) error
+
+	// Migration is a numbered change of the database. Versions are applied
+	// in ascending order and rolled back in descending order.
+	Migration struct {
+		Version     int
+		Description string
+
+		// Up applies the change. It is recorded in a separate write, so Up
+		// runs again if the record is lost and must be idempotent.
+		Up MigrationFunc
+
+		// Down reverts Up. Migrations without Down cannot be rolled back.
+		// Like Up it must be idempotent.
+		Down MigrationFunc
+	}
+
+	// MigrationRecordError is returned when a migration ran but its record
+	// in the migrations collection could not be written. The migration runs
+	// again on the next call.
+	MigrationRecordError struct {
+		Version  int
+		Rollback bool
+		Err      error
+	}
+
+	// AppliedMigration is the record kept in the migrations collection.
+	AppliedMigration struct {
+		Version     int       `bson:"_id"`
+		Description string    `bson:"description"`
+		AppliedAt   time.Time `bson:"applied_at"`
+	}
+
+	// MigrationOptions controls how migrations are run.
+	MigrationOptions struct {
+		// SessionName is the session used, the master session when blank.
+		SessionName string `json:"session"`
+
+		// DatabaseName is the database migrated, the database of the dial
+		// info when blank.
+		DatabaseName string `json:"database"`
+
+		// DryRun returns the migrations that would run without running them.
+		DryRun bool `json:"dry_run"`
+
+		// LockTTL is how long the lock is held without being renewed before
+		// another instance may take it. It is renewed in the background every
+		// third of the TTL while the migrations run.
+		LockTTL Duration `json:"lock_ttl"`
+
+		// LockWait is how long to wait for another instance to release the
+		// lock before failing with ErrMigrationLocked.
+		LockWait Duration `json:"lock_wait"`
+	}
+)
+
+// RegisterMigration adds the migration to the registry. Versions must be
+// positive and unique.
+func RegisterMigration(migration Migration) error {
+	if migration.Version <= 0 {
+		return fmt.Errorf("Invalid Migration Version %d", migration.Version)
+	}
+
+	if migration.Up == nil {
+		return fmt.Errorf("Migration %d Has No Up Function", migration.Version)
+	}
+
+	migrationLock.Lock()
+	defer migrationLock.Unlock()
+
+	if _, ok := migrations[migration.Version]; ok {
+		return fmt.Errorf("Duplicate Migration Version %d", migration.Version)
+	}
+
+	migrations[migration.Version] = migration
+	return nil
+}
+
+// RegisteredMigrations returns the registered migrations by version.
+func RegisteredMigrations() []Migration {
+	migrationLock.RLock()
+	defer migrationLock.RUnlock()
+
+	registered := make([]Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		registered = append(registered, migration)
+	}
+	sort.Sort(byVersion(registered))
+
+	return registered
+}
+
+// AppliedMigrations returns the migrations recorded in the database by
+// version.
+func AppliedMigrations(sessionId string, options MigrationOptions) (applied []AppliedMigration, err error) {
+	err = options.execute(sessionId, "AppliedMigrations", func(database *mgo.Database) error {
+		applied, err = appliedMigrations(database)
+		return err
+	})
+
+	return applied, err
+}
+
+// Migrate applies the registered migrations not recorded in the database
+// in ascending order and returns them. Only one instance migrates at a
+// time, the others wait for the lock.
+func Migrate(sessionId string, options MigrationOptions) (pending []Migration, err error) {
+	err = options.execute(sessionId, "Migrate", func(database *mgo.Database) error {
+		applied, err := appliedVersions(database)
+		if err != nil {
+			return err
+		}
+
+		if pending = pendingMigrations(applied); len(pending) == 0 || options.DryRun {
+			logPlan(sessionId, "Migrate", pending, options.DryRun)
+			return nil
+		}
+
+		return options.withLock(sessionId, database, func(lost func() error) error {
+			// Another instance may have migrated while we waited
+			if applied, err = appliedVersions(database); err != nil {
+				return err
+			}
+			pending = pendingMigrations(applied)
+			logPlan(sessionId, "Migrate", pending, false)
+
+			for index, migration := range pending {
+				if err := migration.apply(sessionId, database); err != nil {
+					pending = pending[:index]
+					return err
+				}
+
+				if err := lost(); err != nil {
+					pending = pending[:index+1]
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+
+	return pending, err
+}
+
+// RollbackTo reverts the applied migrations above the version in
+// descending order and returns them. A version of zero reverts them all.
+func RollbackTo(sessionId string, version int, options MigrationOptions) (reverted []Migration, err error) {
+	err = options.execute(sessionId, "RollbackTo", func(database *mgo.Database) error {
+		applied, err := appliedVersions(database)
+		if err != nil {
+			return err
+		}
+
+		if reverted, err = rollbackMigrations(applied, version); err != nil || len(reverted) == 0 || options.DryRun {
+			if err == nil {
+				logPlan(sessionId, "RollbackTo", reverted, options.DryRun)
+			}
+			return err
+		}
+
+		return options.withLock(sessionId, database, func(lost func() error) error {
+			// Another instance may have changed the versions while we waited
+			if applied, err = appliedVersions(database); err != nil {
+				return err
+			}
+			if reverted, err = rollbackMigrations(applied, version); err != nil {
+				return err
+			}
+			logPlan(sessionId, "RollbackTo", reverted, false)
+
+			for index, migration := range reverted {
+				if err := migration.revert(sessionId, database); err != nil {
+					reverted = reverted[:index]
+					return err
+				}
+
+				if err := lost(); err != nil {
+					reverted = reverted[:index+1]
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+
+	return reverted, err
+}
+
+// apply runs Up and records the migration.
+func (migration Migration) apply(sessionId string, database *mgo.Database) error {
+	tracelog.INFO(sessionId, "Migrate", "Applying Version[%d] Description[%s]", migration.Version, migration.Description)
+
+	if err := migration.Up(database); err != nil {
+		return fmt.Errorf("Migration %d Failed : %v", migration.Version, err)
+	}
+
+	err := database.C(MIGRATIONS_COLLECTION).Insert(AppliedMigration{
+		Version:     migration.Version,
+		Description: migration.Description,
+		AppliedAt:   time.Now().UTC(),
+	})
+
+	if err != nil {
+		err = &MigrationRecordError{Version: migration.Version, Err: err}
+		tracelog.ERROR(err, sessionId, "Migrate")
+		return err
+	}
+
+	return nil
+}
+
+// Error returns the error message for the unrecorded migration.
+func (e *MigrationRecordError) Error() string {
+	if e.Rollback {
+		return fmt.Sprintf("Migration %d Was Rolled Back But Not Recorded : %v", e.Version, e.Err)
+	}
+
+	return fmt.Sprintf("Migration %d Was Applied But Not Recorded : %v", e.Version, e.Err)
+}
+
+// Unwrap returns the error of the write.
+func (e *MigrationRecordError) Unwrap() error {
+	return e.Err
+}
+
+// revert runs Down and removes the record of the migration.
+func (migration Migration) revert(sessionId string, database *mgo.Database) error {
+	tracelog.INFO(sessionId, "RollbackTo", "Reverting Version[%d] Description[%s]", migration.Version, migration.Description)
+
+	if err := migration.Down(database); err != nil {
+		return fmt.Errorf("Rollback Of Migration %d Failed : %v", migration.Version, err)
+	}
+
+	if err := database.C(MIGRATIONS_COLLECTION).RemoveId(migration.Version); err != nil {
+		err = &MigrationRecordError{Version: migration.Version, Rollback: true, Err: err}
+		tracelog.ERROR(err, sessionId, "RollbackTo")
+		return err
+	}
+
+	return nil
+}
+
+// execute copies the session and runs the call against the database.
+func (options *MigrationOptions) execute(sessionId string, operation string, call func(*mgo.Database) error) (err error) {
+	tracelog.STARTEDf(sessionId, operation, "Session[%s] Database[%s] DryRun[%v]", options.sessionName(), options.DatabaseName, options.DryRun)
+
+	mongoSession, err := CopySession(sessionId, options.sessionName())
+	if err != nil {
+		tracelog.COMPLETED_ERROR(err, sessionId, operation)
+		return err
+	}
+	defer CloseSession(sessionId, mongoSession)
+
+	if err = call(mongoSession.DB(options.DatabaseName)); err != nil {
+		tracelog.COMPLETED_ERROR(err, sessionId, operation)
+		return err
+	}
+
+	tracelog.COMPLETED(sessionId, operation)
+	return err
+}
+
+// withLock runs the call holding the distributed lock, which is renewed in
+// the background while the call runs. The call checks lost between steps
+// and stops once the lock could not be renewed. The renewal error is
+// returned when the call itself succeeded.
+func (options *MigrationOptions) withLock(sessionId string, database *mgo.Database, call func(lost func() error) error) error {
+	ttl := time.Duration(options.LockTTL)
+	if ttl <= 0 {
+		ttl = defaultMigrationLockTTL
+	}
+
+	wait := time.Duration(options.LockWait)
+	if wait <= 0 {
+		wait = defaultMigrationLockWait
+	}
+
+	owner, err := lockOwner()
+	if err != nil {
+		return err
+	}
+
+	locks := database.C(MIGRATION_LOCK_COLLECTION)
+	deadline := time.Now().Add(wait)
+
+	for {
+		// The upsert only matches an expired lock. When the lock is held the
+		// insert collides with its _id
+		now := time.Now().UTC()
+		_, err = locks.Upsert(
+			bson.M{"_id": MIGRATION_LOCK_ID, "expires": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": owner, "expires": now.Add(ttl)}},
+		)
+
+		if err == nil {
+			break
+		}
+
+		if !mgo.IsDup(err) {
+			return err
+		}
+
+		if time.Now().After(deadline) {
+			return ErrMigrationLocked
+		}
+
+		tracelog.TRACE(sessionId, "withLock", "Waiting For Migration Lock")
+		time.Sleep(migrationLockRetryInterval)
+	}
+
+	defer func() {
+		if err := locks.Remove(bson.M{"_id": MIGRATION_LOCK_ID, "owner": owner}); err != nil && err != mgo.ErrNotFound {
+			tracelog.ERROR(err, sessionId, "withLock")
+		}
+	}()
+
+	var (
+		lostLock sync.Mutex
+		lostErr  error
+	)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		err := renewLock(sessionId, database, owner, ttl, stop)
+
+		lostLock.Lock()
+		lostErr = err
+		lostLock.Unlock()
+	}()
+
+	lost := func() error {
+		lostLock.Lock()
+		defer lostLock.Unlock()
+
+		return lostErr
+	}
+
+	err = call(lost)
+
+	close(stop)
+	<-done
+
+	if err == nil {
+		err = lost()
+	}
+
+	return err
+}
+
+// renewLock extends the lock every third of the TTL until stop is closed.
+// A failed renewal is retried until the lock expires. It returns
+// ErrMigrationLockLost once the lock is gone.
+func renewLock(sessionId string, database *mgo.Database, owner string, ttl time.Duration, stop chan struct{}) (err error) {
+	defer helper.CatchPanic(&err, sessionId, "renewLock")
+
+	// The call keeps using the session of the database
+	mongoSession := database.Session.Copy()
+	defer mongoSession.Close()
+
+	locks := mongoSession.DB(database.Name).C(MIGRATION_LOCK_COLLECTION)
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	expires := time.Now().Add(ttl)
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		renewed := time.Now()
+		err := locks.Update(
+			bson.M{"_id": MIGRATION_LOCK_ID, "owner": owner},
+			bson.M{"$set": bson.M{"expires": renewed.UTC().Add(ttl)}},
+		)
+
+		if err == nil {
+			expires = renewed.Add(ttl)
+			continue
+		}
+
+		tracelog.ERROR(err, sessionId, "renewLock")
+		if err == mgo.ErrNotFound || !time.Now().Before(expires) {
+			tracelog.ERROR(ErrMigrationLockLost, sessionId, "renewLock")
+			return ErrMigrationLockLost
+		}
+	}
+}
+
+// sessionName returns the session used, the master session when blank.
+func (options *MigrationOptions) sessionName() string {
+	if options.SessionName == "" {
+		return MASTER_SESSION
+	}
+
+	return options.SessionName
+}
+
+// appliedMigrations reads the records of the migrations collection.
+func appliedMigrations(database *mgo.Database) ([]AppliedMigration, error) {
+	applied := []AppliedMigration{}
+	if err := database.C(MIGRATIONS_COLLECTION).Find(nil).Sort("_id").All(&applied); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// appliedVersions returns the set of applied versions.
+func appliedVersions(database *mgo.Database) (map[int]bool, error) {
+	applied, err := appliedMigrations(database)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[int]bool, len(applied))
+	for _, migration := range applied {
+		versions[migration.Version] = true
+	}
+
+	return versions, nil
+}
+
+// pendingMigrations returns the registered migrations not applied.
+func pendingMigrations(applied map[int]bool) []Migration {
+	pending := []Migration{}
+	for _, migration := range RegisteredMigrations() {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending
+}
+
+// rollbackMigrations returns the applied migrations above the version in
+// descending order. Every one must be registered with a Down function.
+func rollbackMigrations(applied map[int]bool, version int) ([]Migration, error) {
+	if version < 0 {
+		return nil, fmt.Errorf("Invalid Migration Version %d", version)
+	}
+
+	versions := []int{}
+	for appliedVersion := range applied {
+		if appliedVersion > version {
+			versions = append(versions, appliedVersion)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	migrationLock.RLock()
+	defer migrationLock.RUnlock()
+
+	reverted := make([]Migration, len(versions))
+	for index, appliedVersion := range versions {
+		migration, ok := migrations[appliedVersion]
+		if !ok {
+			return nil, fmt.Errorf("Migration %d Is Not Registered", appliedVersion)
+		}
+
+		if migration.Down == nil {
+			return nil, fmt.Errorf("Migration %d Cannot Be Rolled Back", appliedVersion)
+		}
+
+		reverted[index] = migration
+	}
+
+	return reverted, nil
+}
+
+// logPlan logs the versions about to run.
+func logPlan(sessionId string, operation string, plan []Migration, dryRun bool) {
+	versions := make([]int, len(plan))
+	for index, migration := range plan {
+		versions[index] = migration.Version
+	}
+
+	tracelog.INFO(sessionId, operation, "Versions%v DryRun[%v]", versions, dryRun)
+}
+
+// lockOwner identifies this instance as the holder of the lock.
+func lockOwner() (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d:%s", hostname, os.Getpid(), id), nil
+}
+
+// byVersion sorts migrations by ascending version.
+type byVersion []Migration
+
+func (s byVersion) Len() int           { return len(s) }
+func (s byVersion) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byVersion) Less(i, j int) bool { return s[i].Version < s[j].Version }
@@ -5,15 +5,12 @@ package mongo
 import (
 	"github.com/ArdanStudios/go-common/helper"
 	"github.com/goinggo/tracelog"
-	"github.com/kelseyhightower/envconfig"
 
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 
 	"encoding/json"
-	"fmt"
-	"strings"
-	"time"
+	"sync"
 )
 
 const (
@@ -42,6 +39,7 @@ type (
 
 	// mongoManager manages a map of session
 	mongoManager struct {
+		lock     sync.RWMutex
 		sessions map[string]*mongoSession
 	}
 
@@ -50,55 +48,112 @@ type (
 	MongoCall func(*mgo.Collection) error
 )
 
-// Startup brings the manager to a running state
+// Startup brings the manager to a running state using the configuration
+// of the MGO environment variables
 func Startup(sessionId string) (err error) {
 	defer helper.CatchPanic(&err, sessionId, "Startup")
 
 	// If the system has already been started ignore the call
-	if singleton != nil {
+	if currentManager() != nil {
 		return err
 	}
 
-	tracelog.STARTED(sessionId, "Startup")
-
 	// Pull in the configuration
-	config := mongoConfiguration{}
-	err = envconfig.Process("mgo", &config)
+	config, err := ConfigFromEnvironment()
 	if err != nil {
 		tracelog.COMPLETED_ERROR(err, sessionId, "Startup")
 		return err
 	}
 
+	return StartupWithConfig(sessionId, config)
+}
+
+// StartupWithConfig brings the manager to a running state creating the
+// sessions of the configuration and running the pending migrations. When
+// any session fails the ones created are closed and a *StartupError lists
+// the failures
+func StartupWithConfig(sessionId string, config *Config) (err error) {
+	defer helper.CatchPanic(&err, sessionId, "StartupWithConfig")
+
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	// If the system has already been started ignore the call
+	if currentManager() != nil {
+		return err
+	}
+
+	tracelog.STARTED(sessionId, "StartupWithConfig")
+
+	if err = config.Validate(); err != nil {
+		tracelog.COMPLETED_ERROR(err, sessionId, "StartupWithConfig")
+		return err
+	}
+
 	// Create the Mongo Manager
-	singleton = &mongoManager{
+	manager := &mongoManager{
 		sessions: map[string]*mongoSession{},
 	}
+	setManager(manager, STATE_STARTING)
+
+	// Create all the configured sessions so every failure is reported
+	startupErr := &StartupError{Errors: map[string]error{}}
+	for _, sessionConfig := range config.Sessions {
+		if err := CreateSessionWithConfig(sessionId, sessionConfig); err != nil {
+			startupErr.Errors[sessionConfig.Name] = err
+		}
+	}
 
-	// Log the mongodb connection straps
-	tracelog.TRACE(sessionId, "Startup", "MongoDB : Hosts[%s]", config.Hosts)
-	tracelog.TRACE(sessionId, "Startup", "MongoDB : Database[%s]", config.Database)
-	tracelog.TRACE(sessionId, "Startup", "MongoDB : Username[%s]", config.UserName)
+	if len(startupErr.Errors) > 0 {
+		manager.closeSessions(sessionId)
+		setManager(nil, STATE_STOPPED)
 
-	hosts := strings.Split(config.Hosts, ",")
+		tracelog.COMPLETED_ERROR(startupErr, sessionId, "StartupWithConfig")
+		return startupErr
+	}
+
+	// Bring the database up to date before the sessions are used
+	if !config.SkipMigrations && len(RegisteredMigrations()) > 0 {
+		if _, err = Migrate(sessionId, config.Migrations); err != nil {
+			manager.closeSessions(sessionId)
+			setManager(nil, STATE_STOPPED)
+
+			tracelog.COMPLETED_ERROR(err, sessionId, "StartupWithConfig")
+			return err
+		}
+	}
 
-	// Create the strong and monotonic sessions
-	err = CreateSession(sessionId, "strong", MASTER_SESSION, hosts, config.Database, config.UserName, config.Password)
-	err = CreateSession(sessionId, "monotonic", MONOTONIC_SESSION, hosts, config.Database, config.UserName, config.Password)
+	setState(STATE_RUNNING)
 
-	tracelog.COMPLETED(sessionId, "Startup")
+	tracelog.COMPLETED(sessionId, "StartupWithConfig")
 	return err
 }
 
-// Shutdown systematically brings the manager down gracefully
+// Shutdown systematically brings the manager down gracefully. It can be
+// called when the manager is not running and Startup may be called again
 func Shutdown(sessionId string) (err error) {
 	defer helper.CatchPanic(&err, sessionId, "Shutdown")
 
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	manager := currentManager()
+	if manager == nil {
+		return err
+	}
+
 	tracelog.STARTED(sessionId, "Shutdown")
 
+	// Stop checking the sessions before closing them
+	StopHealthMonitor()
+
+	setState(STATE_STOPPING)
+
 	// Close the databases
-	for _, session := range singleton.sessions {
-		CloseSession(sessionId, session.mongoSession)
-	}
+	manager.closeSessions(sessionId)
+
+	setManager(nil, STATE_STOPPED)
+	resetHealth()
 
 	tracelog.COMPLETED(sessionId, "Shutdown")
 	return err
@@ -106,19 +161,32 @@ func Shutdown(sessionId string) (err error) {
 
 // CreateSession creates a connection pool for use
 func CreateSession(sessionId string, mode string, sessionName string, hosts []string, databaseName string, username string, password string) (err error) {
-	defer helper.CatchPanic(nil, sessionId, "CreateSession")
+	return CreateSessionWithConfig(sessionId, SessionConfig{
+		Name:     sessionName,
+		Hosts:    hosts,
+		Database: databaseName,
+		UserName: username,
+		Password: password,
+		Mode:     mode,
+	})
+}
+
+// CreateSessionWithConfig creates a connection pool for use with the
+// session configuration
+func CreateSessionWithConfig(sessionId string, sessionConfig SessionConfig) (err error) {
+	defer helper.CatchPanic(&err, sessionId, "CreateSession")
 
-	tracelog.STARTEDf(sessionId, "CreateSession", "Mode[%s] SessionName[%s] Hosts[%s] DatabaseName[%s] Username[%s]", mode, sessionName, hosts, databaseName, username)
+	tracelog.STARTEDf(sessionId, "CreateSession", "Mode[%s] SessionName[%s] Hosts[%s] DatabaseName[%s] Username[%s] ReplicaSet[%s]", sessionConfig.Mode, sessionConfig.Name, sessionConfig.Hosts, sessionConfig.Database, sessionConfig.UserName, sessionConfig.ReplicaSet)
+
+	manager := currentManager()
+	if manager == nil {
+		tracelog.COMPLETED_ERROR(ErrNotRunning, sessionId, "CreateSession")
+		return ErrNotRunning
+	}
 
 	// Create the database object
 	mongoSession := &mongoSession{
-		mongoDBDialInfo: &mgo.DialInfo{
-			Addrs:    hosts,
-			Timeout:  60 * time.Second,
-			Database: databaseName,
-			Username: username,
-			Password: password,
-		},
+		mongoDBDialInfo: sessionConfig.dialInfo(),
 	}
 
 	// Establish the master session
@@ -128,22 +196,11 @@ func CreateSession(sessionId string, mode string, sessionName string, hosts []st
 		return err
 	}
 
-	switch mode {
-	case "strong":
-		// Reads and writes will always be made to the master server using a
-		// unique connection so that reads and writes are fully consistent,
-		// ordered, and observing the most up-to-date data.
-		// http://godoc.org/labix.org/v2/mgo#Session.SetMode
-		mongoSession.mongoSession.SetMode(mgo.Strong, true)
-		break
-
-	case "monotonic":
-		// Reads may not be entirely up-to-date, but they will always see the
-		// history of changes moving forward, the data read will be consistent
-		// across sequential queries in the same session, and modifications made
-		// within the session will be observed in following queries (read-your-writes).
-		// http://godoc.org/labix.org/v2/mgo#Session.SetMode
-		mongoSession.mongoSession.SetMode(mgo.Monotonic, true)
+	// Apply the consistency mode, timeouts and read preferences
+	if err = sessionConfig.configure(mongoSession.mongoSession); err != nil {
+		mongoSession.mongoSession.Close()
+		tracelog.COMPLETED_ERROR(err, sessionId, "CreateSession")
+		return err
 	}
 
 	// Have the session check for errors
@@ -151,7 +208,7 @@ func CreateSession(sessionId string, mode string, sessionName string, hosts []st
 	mongoSession.mongoSession.SetSafe(&mgo.Safe{})
 
 	// Add the database to the map
-	singleton.sessions[sessionName] = mongoSession
+	manager.addSession(sessionConfig.Name, mongoSession)
 
 	tracelog.COMPLETED(sessionId, "CreateSession")
 	return err
@@ -169,15 +226,13 @@ func CopyMonotonicSession(sessionId string) (*mgo.Session, error) {
 
 // CopySession makes a copy of the specified session for client use
 func CopySession(sessionId string, useSession string) (mongoSession *mgo.Session, err error) {
-	defer helper.CatchPanic(nil, sessionId, "CopySession")
+	defer helper.CatchPanic(&err, sessionId, "CopySession")
 
 	tracelog.STARTEDf(sessionId, "CopySession", "UseSession[%s]", useSession)
 
 	// Find the session object
-	session := singleton.sessions[useSession]
-
-	if session == nil {
-		err = fmt.Errorf("Unable To Locate Session %s", useSession)
+	session, err := findSession(useSession)
+	if err != nil {
 		tracelog.COMPLETED_ERROR(err, sessionId, "CopySession")
 		return mongoSession, err
 	}
@@ -201,15 +256,13 @@ func CloneMonotonicSession(sessionId string) (*mgo.Session, error) {
 
 // CloneSession makes a clone of the specified session for client use
 func CloneSession(sessionId string, useSession string) (mongoSession *mgo.Session, err error) {
-	defer helper.CatchPanic(nil, sessionId, "CopySession")
+	defer helper.CatchPanic(&err, sessionId, "CloneSession")
 
 	tracelog.STARTEDf(sessionId, "CloneSession", "UseSession[%s]", useSession)
 
 	// Find the session object
-	session := singleton.sessions[useSession]
-
-	if session == nil {
-		err = fmt.Errorf("Unable To Locate Session %s", useSession)
+	session, err := findSession(useSession)
+	if err != nil {
 		tracelog.COMPLETED_ERROR(err, sessionId, "CloneSession")
 		return mongoSession, err
 	}
@@ -225,6 +278,10 @@ func CloneSession(sessionId string, useSession string) (mongoSession *mgo.Sessio
 func CloseSession(sessionId string, mongoSession *mgo.Session) {
 	defer helper.CatchPanic(nil, sessionId, "CloseSession")
 
+	if mongoSession == nil {
+		return
+	}
+
 	tracelog.STARTED(sessionId, "CloseSession")
 
 	mongoSession.Close()
@@ -0,0 +1,242 @@
+package mongo
+
+import (
+	"strings"
+
+	"github.com/goinggo/tracelog"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+const (
+	// REDACTED replaces the values of the redacted fields in the logs.
+	REDACTED = "[REDACTED]"
+)
+
+var (
+	// RedactedFields lists the fields whose values are masked when the
+	// repositories log their queries. Names are matched ignoring case
+	// against the last segment of a dotted path.
+	RedactedFields = []string{"password", "secret", "token", "apikey", "api_key", "ssn"}
+)
+
+type (
+	// Repository provides the common operations against a collection of
+	// documents of type T. Each call copies the named session and closes
+	// it when done.
+	Repository[T any] struct {
+		SessionName    string
+		DatabaseName   string
+		CollectionName string
+	}
+
+	// FindOptions controls the documents returned by FindMany.
+	FindOptions struct {
+		// Sort lists the fields to sort on, prefixed by - for descending.
+		Sort []string
+
+		Skip  int
+		Limit int
+
+		// Select, if non-nil, restricts the fields returned.
+		Select bson.M
+	}
+)
+
+// NewRepository creates a repository for the collection using the master
+// session. A blank database name uses the database of the dial info.
+func NewRepository[T any](databaseName string, collectionName string) *Repository[T] {
+	return &Repository[T]{
+		SessionName:    MASTER_SESSION,
+		DatabaseName:   databaseName,
+		CollectionName: collectionName,
+	}
+}
+
+// FindByID returns the document with the specified _id. mgo.ErrNotFound is
+// returned when it does not exist.
+func (repository *Repository[T]) FindByID(sessionId string, id interface{}) (*T, error) {
+	return repository.findOne(sessionId, "FindByID", bson.M{"_id": id})
+}
+
+// FindOne returns the first document matching the query. mgo.ErrNotFound
+// is returned when none matches.
+func (repository *Repository[T]) FindOne(sessionId string, query bson.M) (*T, error) {
+	return repository.findOne(sessionId, "FindOne", query)
+}
+
+// FindMany returns the documents matching the query. Options may be nil.
+func (repository *Repository[T]) FindMany(sessionId string, query bson.M, options *FindOptions) (results []T, err error) {
+	results = []T{}
+	err = repository.execute(sessionId, "FindMany", query, func(collection *mgo.Collection) error {
+		q := collection.Find(query)
+
+		if options != nil {
+			if len(options.Sort) > 0 {
+				q = q.Sort(options.Sort...)
+			}
+			if options.Skip > 0 {
+				q = q.Skip(options.Skip)
+			}
+			if options.Limit > 0 {
+				q = q.Limit(options.Limit)
+			}
+			if options.Select != nil {
+				q = q.Select(options.Select)
+			}
+		}
+
+		return q.All(&results)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Insert inserts the documents.
+func (repository *Repository[T]) Insert(sessionId string, docs ...*T) error {
+	values := make([]interface{}, len(docs))
+	for index, doc := range docs {
+		values[index] = doc
+	}
+
+	return repository.execute(sessionId, "Insert", nil, func(collection *mgo.Collection) error {
+		return collection.Insert(values...)
+	})
+}
+
+// Update applies the update to the first document matching the selector.
+// mgo.ErrNotFound is returned when none matches.
+func (repository *Repository[T]) Update(sessionId string, selector bson.M, update interface{}) error {
+	return repository.execute(sessionId, "Update", selector, func(collection *mgo.Collection) error {
+		return collection.Update(selector, update)
+	})
+}
+
+// Upsert applies the update to the first document matching the selector
+// or inserts it when none matches.
+func (repository *Repository[T]) Upsert(sessionId string, selector bson.M, update interface{}) (changeInfo *mgo.ChangeInfo, err error) {
+	err = repository.execute(sessionId, "Upsert", selector, func(collection *mgo.Collection) error {
+		changeInfo, err = collection.Upsert(selector, update)
+		return err
+	})
+
+	return changeInfo, err
+}
+
+// Delete removes the documents matching the selector and returns how many
+// were removed.
+func (repository *Repository[T]) Delete(sessionId string, selector bson.M) (removed int, err error) {
+	err = repository.execute(sessionId, "Delete", selector, func(collection *mgo.Collection) error {
+		changeInfo, err := collection.RemoveAll(selector)
+		if changeInfo != nil {
+			removed = changeInfo.Removed
+		}
+		return err
+	})
+
+	return removed, err
+}
+
+// Count returns the number of documents matching the query.
+func (repository *Repository[T]) Count(sessionId string, query bson.M) (count int, err error) {
+	err = repository.execute(sessionId, "Count", query, func(collection *mgo.Collection) error {
+		count, err = collection.Find(query).Count()
+		return err
+	})
+
+	return count, err
+}
+
+// findOne decodes the first document matching the query.
+func (repository *Repository[T]) findOne(sessionId string, operation string, query bson.M) (*T, error) {
+	result := new(T)
+	err := repository.execute(sessionId, operation, query, func(collection *mgo.Collection) error {
+		return collection.Find(query).One(result)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// execute copies the session, logs the query and runs the call against
+// the collection. The values of the RedactedFields are masked in the log.
+func (repository *Repository[T]) execute(sessionId string, operation string, query bson.M, mongoCall MongoCall) error {
+	tracelog.TRACE(sessionId, "Repository."+operation, "Database[%s] Collection[%s] Query[%s]", repository.DatabaseName, repository.CollectionName, ToString(redactQuery(query)))
+
+	mongoSession, err := CopySession(sessionId, repository.SessionName)
+	if err != nil {
+		return err
+	}
+	defer CloseSession(sessionId, mongoSession)
+
+	return Execute(sessionId, mongoSession, repository.DatabaseName, repository.CollectionName, mongoCall)
+}
+
+// redactQuery returns a copy of the query with the values of the
+// RedactedFields masked.
+func redactQuery(query bson.M) bson.M {
+	if query == nil {
+		return nil
+	}
+
+	return redactValue(query).(bson.M)
+}
+
+// redactValue masks the redacted fields found in the documents and arrays
+// of the value.
+func redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case bson.M:
+		redacted := make(bson.M, len(value))
+		for key, item := range value {
+			redacted[key] = redactField(key, item)
+		}
+		return redacted
+
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			redacted[key] = redactField(key, item)
+		}
+		return redacted
+
+	case bson.D:
+		redacted := make(bson.D, len(value))
+		for index, item := range value {
+			redacted[index] = bson.DocElem{Name: item.Name, Value: redactField(item.Name, item.Value)}
+		}
+		return redacted
+
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for index, item := range value {
+			redacted[index] = redactValue(item)
+		}
+		return redacted
+	}
+
+	return value
+}
+
+// redactField masks the value when the field is redacted.
+func redactField(key string, value interface{}) interface{} {
+	if index := strings.LastIndex(key, "."); index >= 0 {
+		key = key[index+1:]
+	}
+
+	for _, field := range RedactedFields {
+		if strings.EqualFold(key, field) {
+			return REDACTED
+		}
+	}
+
+	return redactValue(value)
+}
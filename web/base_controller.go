@@ -7,6 +7,7 @@ import (
 	"reflect"
 
 	"github.com/ArdanStudios/go-common/appErrors"
+	"github.com/ArdanStudios/go-common/crypto"
 	"github.com/ArdanStudios/go-common/helper"
 	"github.com/ArdanStudios/go-common/localize"
 	"github.com/astaxie/beego"
@@ -77,6 +78,26 @@ func (baseController *BaseController) ServeUnAuthorized() {
 	baseController.ServeMessageWithStatus(appErrors.UNAUTHORIZED_ERROR_CODE, localize.T(appErrors.UNAUTHORIZED_ERROR_MSG))
 }
 
+// VerifySignature checks the signature headers of the request and serves an
+// Unauthorized error when they are not valid. The copied body may be gzip
+// encoded or decoded, the signature covers the decoded body.
+func (baseController *BaseController) VerifySignature(verifier *crypto.RequestVerifier) bool {
+	var err error
+	if body := baseController.Ctx.Input.RequestBody; len(body) > 0 {
+		err = verifier.VerifyBody(baseController.Ctx.Request, body)
+	} else {
+		err = verifier.Verify(baseController.Ctx.Request)
+	}
+
+	if err != nil {
+		tracelog.ERROR(err, "BaseController", "VerifySignature")
+		baseController.ServeUnAuthorized()
+		return false
+	}
+
+	return true
+}
+
 // ServeValidationError returns a Validation Error's list of messages with a validation err code.
 func (baseController *BaseController) ServeValidationError() {
 	baseController.Ctx.Output.SetStatus(appErrors.VALIDATION_ERROR_CODE)